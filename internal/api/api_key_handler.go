@@ -0,0 +1,160 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
+	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+)
+
+type APIKeyHandler struct {
+	apiKeyStore store.APIKeyStore
+	logger      *log.Logger
+}
+
+type createAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+func NewAPIKeyHandler(apiKeyStore store.APIKeyStore, logger *log.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyStore: apiKeyStore,
+		logger:      logger,
+	}
+}
+
+func (req *createAPIKeyRequest) validate() error {
+	if req.Name == "" || len(req.Name) > 100 {
+		return errors.New("name is required and must be at most 100 characters")
+	}
+	if len(req.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(tokens.APIScopes, scope) {
+			return errors.New("unknown scope: " + scope)
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		return errors.New("expires_in_days must not be negative")
+	}
+	return nil
+}
+
+// @Summary      Create an API key
+// @Description  Creates a named API key with restricted scopes for scripts and integrations.
+// @Description  The key is only returned once; store it securely.
+// @Tags         API Keys
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param key body createAPIKeyRequest true "Key name, scopes and optional lifetime"
+//
+// @Success      201 {object} utils.Envelope{api_key=store.APIKey} "Returns the key and its plaintext value"
+// @Failure      400 {object} utils.Envelope "Invalid input or bad request"
+// @Failure      500 {object} utils.Envelope "Server error while creating the key"
+//
+// @Router       /api-keys [post]
+func (ah *APIKeyHandler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ah.logger.Printf("failed to decode create api key request: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	err = req.validate()
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	plaintext, hash, err := tokens.GenerateAPIKey()
+	if err != nil {
+		ah.logger.Printf("failed to generate api key: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create api key"})
+		return
+	}
+	key := &store.APIKey{
+		UserID: middleware.GetUser(r).ID,
+		Name:   req.Name,
+		Prefix: plaintext[:len(tokens.APIKeyPrefix)+6],
+		Hash:   hash,
+		Scopes: req.Scopes,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		key.ExpiresAt = &expiresAt
+	}
+
+	createdKey, err := ah.apiKeyStore.CreateAPIKey(key)
+	if err != nil {
+		ah.logger.Printf("failed to create api key: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create api key"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"api_key": createdKey, "key": plaintext})
+}
+
+// @Summary      List API keys
+// @Description  Lists the current user's API keys with their scopes and last use. Key values are never returned.
+// @Tags         API Keys
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Success      200 {object} utils.Envelope{api_keys=[]store.APIKey} "Returns the keys"
+// @Failure      500 {object} utils.Envelope "Server error while fetching keys"
+//
+// @Router       /api-keys [get]
+func (ah *APIKeyHandler) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := ah.apiKeyStore.GetAPIKeysForUser(middleware.GetUser(r).ID)
+	if err != nil {
+		ah.logger.Printf("failed to get api keys: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch api keys"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"api_keys": keys})
+}
+
+// @Summary      Revoke an API key
+// @Tags         API Keys
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param id path int true "API key ID"
+//
+// @Success      204 "Key revoked"
+// @Failure      400 {object} utils.Envelope "Invalid key ID"
+// @Failure      404 {object} utils.Envelope "Key not found"
+// @Failure      500 {object} utils.Envelope "Server error while revoking the key"
+//
+// @Router       /api-keys/{id} [delete]
+func (ah *APIKeyHandler) HandleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := utils.ReadIDParams(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid api key id"})
+		return
+	}
+	err = ah.apiKeyStore.DeleteAPIKey(keyID, middleware.GetUser(r).ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "api key not found"})
+		return
+	}
+	if err != nil {
+		ah.logger.Printf("failed to delete api key: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to revoke api key"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register the GIF decoder for image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/blob"
+	"github.com/alireza-akbarzadeh/fem_project/internal/imaging"
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+)
+
+const (
+	maxAvatarBytes     = 5 << 20
+	maxAvatarDimension = 4096
+	avatarOriginal     = "original"
+)
+
+// avatarSizes are the thumbnail edge lengths generated for every upload;
+// the first one is served when no size is requested.
+var avatarSizes = []int{256, 64}
+
+var avatarFormats = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+type AvatarHandler struct {
+	userStore store.UserStore
+	blobs     blob.Store
+	logger    *log.Logger
+}
+
+func NewAvatarHandler(userStore store.UserStore, blobs blob.Store, logger *log.Logger) *AvatarHandler {
+	return &AvatarHandler{
+		userStore: userStore,
+		blobs:     blobs,
+		logger:    logger,
+	}
+}
+
+// AvatarKeys lists the blobs stored for an avatar under prefix, one per size.
+func AvatarKeys(prefix string) []string {
+	keys := []string{prefix + "/" + avatarOriginal}
+	for _, size := range avatarSizes {
+		keys = append(keys, prefix+"/"+strconv.Itoa(size))
+	}
+	return keys
+}
+
+// encodeAvatar re-encodes the original so metadata such as EXIF location is
+// dropped, and renders the square JPEG thumbnails.
+func encodeAvatar(img image.Image, format string) (map[string][]byte, error) {
+	blobs := make(map[string][]byte)
+
+	var buf bytes.Buffer
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	blobs[avatarOriginal] = buf.Bytes()
+
+	for _, size := range avatarSizes {
+		var buf bytes.Buffer
+		thumb := imaging.Flatten(imaging.Thumbnail(img, size), color.White)
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		if err != nil {
+			return nil, err
+		}
+		blobs[strconv.Itoa(size)] = buf.Bytes()
+	}
+	return blobs, nil
+}
+
+func (ah *AvatarHandler) deleteBlobs(prefix string) {
+	for _, key := range AvatarKeys(prefix) {
+		err := ah.blobs.Delete(key)
+		if err != nil {
+			ah.logger.Printf("failed to delete avatar blob %s: %v", key, err)
+		}
+	}
+}
+
+// @Summary      Upload an avatar
+// @Description  Replaces the current user's avatar with a JPEG, PNG or GIF image of at most 5 MB
+// @Description  and 4096x4096 pixels, sent as the "avatar" field of a multipart form.
+// @Description  Square thumbnails are generated from the center of the image.
+// @Tags         Users
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param avatar formData file true "Avatar image"
+//
+// @Success      200 {object} utils.Envelope "Avatar updated"
+// @Failure      400 {object} utils.Envelope "Missing or unreadable image"
+// @Failure      413 {object} utils.Envelope "Image is larger than 5 MB"
+// @Failure      415 {object} utils.Envelope "Image is not a JPEG, PNG or GIF"
+// @Failure      500 {object} utils.Envelope "Server error while storing the avatar"
+//
+// @Router       /users/me/avatar [put]
+func (ah *AvatarHandler) HandleUploadAvatar(w http.ResponseWriter, r *http.Request) {
+	// leave room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarBytes+64<<10)
+	file, _, err := r.FormFile("avatar")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		utils.WriteJSON(w, http.StatusRequestEntityTooLarge, utils.Envelope{"error": "avatar must be at most 5 MB"})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "avatar file is required"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarBytes+1))
+	if err != nil {
+		ah.logger.Printf("failed to read avatar upload: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "failed to read avatar"})
+		return
+	}
+	if len(data) > maxAvatarBytes {
+		utils.WriteJSON(w, http.StatusRequestEntityTooLarge, utils.Envelope{"error": "avatar must be at most 5 MB"})
+		return
+	}
+	if !avatarFormats[http.DetectContentType(data)] {
+		utils.WriteJSON(w, http.StatusUnsupportedMediaType, utils.Envelope{"error": "avatar must be a JPEG, PNG or GIF image"})
+		return
+	}
+
+	// check the dimensions before decoding so a tiny file cannot claim a huge canvas
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "avatar is not a valid image"})
+		return
+	}
+	if config.Width < 1 || config.Height < 1 || config.Width > maxAvatarDimension || config.Height > maxAvatarDimension {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": fmt.Sprintf("avatar must be at most %dx%d pixels", maxAvatarDimension, maxAvatarDimension)})
+		return
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "avatar is not a valid image"})
+		return
+	}
+
+	blobs, err := encodeAvatar(img, format)
+	if err != nil {
+		ah.logger.Printf("failed to encode avatar: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to process avatar"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	previous := user.AvatarKey
+	// every upload gets a fresh prefix so cached copies of the old avatar are never served as the new one
+	prefix := fmt.Sprintf("avatars/%d/%s", user.ID, rand.Text())
+	for name, content := range blobs {
+		err = ah.blobs.Put(prefix+"/"+name, bytes.NewReader(content))
+		if err != nil {
+			ah.logger.Printf("failed to store avatar: %v", err)
+			ah.deleteBlobs(prefix)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to store avatar"})
+			return
+		}
+	}
+
+	err = ah.userStore.SetAvatarKey(user.ID, prefix)
+	if err != nil {
+		ah.logger.Printf("failed to set avatar key: %v", err)
+		ah.deleteBlobs(prefix)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to store avatar"})
+		return
+	}
+	if previous != "" {
+		ah.deleteBlobs(previous)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "avatar updated successfully"})
+}
+
+// @Summary      Remove the avatar
+// @Description  Deletes the current user's avatar and its thumbnails.
+// @Tags         Users
+// @Security     BearerAuth
+//
+// @Success      204 "Avatar removed"
+// @Failure      500 {object} utils.Envelope "Server error while removing the avatar"
+//
+// @Router       /users/me/avatar [delete]
+func (ah *AvatarHandler) HandleDeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	previous := user.AvatarKey
+	if previous != "" {
+		err := ah.userStore.SetAvatarKey(user.ID, "")
+		if err != nil {
+			ah.logger.Printf("failed to clear avatar key: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to remove avatar"})
+			return
+		}
+		ah.deleteBlobs(previous)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Get a user's avatar
+// @Description  Returns the avatar image of a user. size selects a square thumbnail (256 or 64)
+// @Description  or the uploaded image ("original"); it defaults to 256.
+// @Tags         Users
+// @Produce      image/jpeg,image/png
+// @Security     BearerAuth
+//
+// @Param id   path  int    true  "User ID"
+// @Param size query string false "256, 64 or original"
+//
+// @Success      200 {file} file "Avatar image"
+// @Success      304 "Not modified"
+// @Failure      400 {object} utils.Envelope "Invalid user ID or size"
+// @Failure      404 {object} utils.Envelope "User has no avatar"
+// @Failure      500 {object} utils.Envelope "Server error while reading the avatar"
+//
+// @Router       /users/{id}/avatar [get]
+func (ah *AvatarHandler) HandleGetAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.ReadIDParams(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user ID"})
+		return
+	}
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = strconv.Itoa(avatarSizes[0])
+	}
+	user, err := ah.userStore.GetUserByID(userID)
+	if err != nil {
+		ah.logger.Printf("failed to get user by ID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch avatar"})
+		return
+	}
+	if user == nil || user.AvatarKey == "" {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "avatar not found"})
+		return
+	}
+	key := ""
+	for _, k := range AvatarKeys(user.AvatarKey) {
+		if path.Base(k) == size {
+			key = k
+		}
+	}
+	if key == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "size must be one of 256, 64 or original"})
+		return
+	}
+
+	etag := `"` + path.Base(user.AvatarKey) + "-" + size + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	rc, err := ah.blobs.Get(key)
+	if errors.Is(err, blob.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "avatar not found"})
+		return
+	}
+	if err != nil {
+		ah.logger.Printf("failed to open avatar: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch avatar"})
+		return
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		ah.logger.Printf("failed to read avatar: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch avatar"})
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/blob"
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func avatarUpload(t *testing.T, user *store.User, content []byte) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("avatar", "avatar.png")
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPut, "/users/me/avatar", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return middleware.SetUser(req, user)
+}
+
+func TestAvatarUploadAndGet(t *testing.T) {
+	user := &store.User{ID: 1}
+	blobs := blob.NewDiskStore(t.TempDir())
+	ah := NewAvatarHandler(&fakeUserStore{users: map[int]*store.User{1: user}}, blobs, log.New(io.Discard, "", 0))
+
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 300; x++ {
+			img.Set(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+	var content bytes.Buffer
+	require.NoError(t, png.Encode(&content, img))
+
+	rr := httptest.NewRecorder()
+	ah.HandleUploadAvatar(rr, avatarUpload(t, user, content.Bytes()))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NotEmpty(t, user.AvatarKey)
+	firstKey := user.AvatarKey
+
+	r := chi.NewRouter()
+	r.Get("/users/{id}/avatar", ah.HandleGetAvatar)
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/1/avatar?size=64", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "image/jpeg", rr.Header().Get("Content-Type"))
+	thumb, err := jpeg.Decode(rr.Body)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 64, 64), thumb.Bounds())
+
+	etag := rr.Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodGet, "/users/1/avatar?size=64", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/1/avatar?size=original", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/1/avatar?size=1024", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// a new upload replaces the old blobs
+	rr = httptest.NewRecorder()
+	ah.HandleUploadAvatar(rr, avatarUpload(t, user, content.Bytes()))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEqual(t, firstKey, user.AvatarKey)
+	_, err = blobs.Get(firstKey + "/64")
+	assert.ErrorIs(t, err, blob.ErrNotFound)
+}
+
+func TestAvatarUploadValidation(t *testing.T) {
+	user := &store.User{ID: 1}
+	ah := NewAvatarHandler(&fakeUserStore{users: map[int]*store.User{1: user}}, blob.NewDiskStore(t.TempDir()), log.New(io.Discard, "", 0))
+
+	var huge bytes.Buffer
+	require.NoError(t, png.Encode(&huge, image.NewGray(image.Rect(0, 0, 5000, 1))))
+
+	tests := []struct {
+		name           string
+		content        []byte
+		expectedStatus int
+	}{
+		{name: "not an image", content: []byte("hello, world"), expectedStatus: http.StatusUnsupportedMediaType},
+		{name: "too many pixels", content: huge.Bytes(), expectedStatus: http.StatusBadRequest},
+		{name: "truncated png", content: huge.Bytes()[:40], expectedStatus: http.StatusBadRequest},
+		{name: "too large", content: append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, maxAvatarBytes)...), expectedStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			ah.HandleUploadAvatar(rr, avatarUpload(t, user, tt.content))
+			assert.Equal(t, tt.expectedStatus, rr.Code, rr.Body.String())
+			assert.Empty(t, user.AvatarKey)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+)
+
+// etagMatches reports whether an If-Match or If-None-Match header value is
+// "*" or lists etag. If-Match requires the strong comparison, so weak
+// validators never match it; If-None-Match compares weakly (RFC 9110,
+// section 8.8.3.2).
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// workoutETag changes whenever the workout or one of its entries does.
+func workoutETag(workout *store.Workout) string {
+	return fmt.Sprintf(`"%d-%d"`, workout.ID, workout.Version)
+}
+
+func writePreconditionFailed(w http.ResponseWriter, current *store.Workout) {
+	if current != nil {
+		w.Header().Set("ETag", workoutETag(current))
+	}
+	utils.WriteJSON(w, http.StatusPreconditionFailed, utils.Envelope{"error": "the workout has been modified since you last fetched it"})
+}
+
+// ifMatchVersion checks the If-Match header of a write against the stored
+// workout and returns the version the write must be conditional on, or 0 for
+// requests without If-Match. It writes an error response and returns false
+// when the precondition fails.
+func (wh *WorkoutHandler) ifMatchVersion(w http.ResponseWriter, r *http.Request, workoutID int64) (int, bool) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return 0, true
+	}
+	current, err := wh.workoutStore.GetWorkoutByID(workoutID, middleware.GetUser(r).ID)
+	if err != nil {
+		wh.logger.Printf("failed to get workout by id:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch workout"})
+		return 0, false
+	}
+	if current == nil || !etagMatches(ifMatch, workoutETag(current), false) {
+		writePreconditionFailed(w, current)
+		return 0, false
+	}
+	return current.Version, true
+}
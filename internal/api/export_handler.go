@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/export"
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+)
+
+type ExportHandler struct {
+	workoutStore store.WorkoutStore
+	tokenStore   store.TokenStore
+	apiKeyStore  store.APIKeyStore
+	logger       *log.Logger
+}
+
+func NewExportHandler(workoutStore store.WorkoutStore, tokenStore store.TokenStore, apiKeyStore store.APIKeyStore, logger *log.Logger) *ExportHandler {
+	return &ExportHandler{
+		workoutStore: workoutStore,
+		tokenStore:   tokenStore,
+		apiKeyStore:  apiKeyStore,
+		logger:       logger,
+	}
+}
+
+// @Summary      Export the current user's data
+// @Description  Downloads a ZIP archive with the profile, every workout with its entries, and
+// @Description  session and API key metadata, each as JSON and CSV.
+// @Tags         Users
+// @Produce      application/zip
+// @Security     BearerAuth
+//
+// @Success      200 {file} file "ZIP archive"
+// @Failure      500 {object} utils.Envelope "Server error while building the export"
+//
+// @Router       /users/me/export [get]
+func (eh *ExportHandler) HandleExport(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	workouts, err := eh.workoutStore.GetWorkoutsWithEntries(user.ID)
+	if err != nil {
+		eh.logger.Printf("failed to get workouts for export: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to build export"})
+		return
+	}
+	currentHash := sha256.Sum256([]byte(middleware.GetToken(r)))
+	sessions, err := eh.tokenStore.GetSessionsForUser(user.ID, currentHash[:])
+	if err != nil {
+		eh.logger.Printf("failed to get sessions for export: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to build export"})
+		return
+	}
+	apiKeys, err := eh.apiKeyStore.GetAPIKeysForUser(user.ID)
+	if err != nil {
+		eh.logger.Printf("failed to get api keys for export: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to build export"})
+		return
+	}
+
+	// build the archive in memory so a failure can still be reported as JSON
+	var buf bytes.Buffer
+	now := time.Now()
+	err = export.WriteZip(&buf, &export.Data{
+		User:        user,
+		Workouts:    workouts,
+		Sessions:    sessions,
+		APIKeys:     apiKeys,
+		GeneratedAt: now,
+	})
+	if err != nil {
+		eh.logger.Printf("failed to write export archive: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to build export"})
+		return
+	}
+
+	filename := fmt.Sprintf("fem-export-%d-%s.zip", user.ID, now.UTC().Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
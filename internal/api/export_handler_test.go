@@ -0,0 +1,128 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func (f *fakeWorkoutStore) GetWorkoutsWithEntries(userID int) ([]*store.Workout, error) {
+	workouts := []*store.Workout{}
+	for _, workout := range f.workouts {
+		if workout.UserID == userID {
+			workouts = append(workouts, workout)
+		}
+	}
+	return workouts, nil
+}
+
+type fakeAPIKeyStore struct {
+	store.APIKeyStore
+	keys []*store.APIKey
+	err  error
+}
+
+func (f *fakeAPIKeyStore) GetAPIKeysForUser(userID int) ([]*store.APIKey, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	keys := []*store.APIKey{}
+	for _, key := range f.keys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func TestHandleExport(t *testing.T) {
+	tests := []struct {
+		name           string
+		apiKeyErr      error
+		expectedStatus int
+	}{
+		{name: "archive", expectedStatus: http.StatusOK},
+		{name: "store error", apiKeyErr: errors.New("connection reset"), expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workoutStore := &fakeWorkoutStore{workouts: []*store.Workout{
+				{ID: 1, UserID: 1, Title: "Legs", Entries: []store.WorkoutEntry{{ID: 1, WorkoutID: 1, ExerciseName: "Squat", Sets: 3, OrderIndex: 1}}},
+				{ID: 2, UserID: 2, Title: "Bob's run"},
+			}}
+			tokenStore := &fakeTokenStore{}
+			tokenStore.add("alice-access", 1, tokens.ScopeAuth, "alice")
+			tokenStore.add("alice-refresh", 1, tokens.ScopeRefresh, "alice")
+			tokenStore.add("bob-refresh", 2, tokens.ScopeRefresh, "bob")
+			apiKeyStore := &fakeAPIKeyStore{
+				keys: []*store.APIKey{{ID: 1, UserID: 1, Name: "script", Prefix: "fem_abcd", Hash: []byte("secret"), Scopes: []string{tokens.APIScopeWorkoutsRead}}},
+				err:  tt.apiKeyErr,
+			}
+			eh := NewExportHandler(workoutStore, tokenStore, apiKeyStore, log.New(io.Discard, "", 0))
+
+			alice := &store.User{ID: 1, Username: "alice", Email: "alice@example.com"}
+			req := httptest.NewRequest(http.MethodGet, "/users/me/export", nil)
+			req = middleware.SetUser(req, alice)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.TokenContextKey, "alice-access"))
+			rr := httptest.NewRecorder()
+			eh.HandleExport(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedStatus != http.StatusOK {
+				assert.JSONEq(t, `{"error":"failed to build export"}`, rr.Body.String())
+				return
+			}
+			assert.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
+			assert.Equal(t, `attachment; filename="fem-export-1-`+time.Now().UTC().Format("20060102")+`.zip"`, rr.Header().Get("Content-Disposition"))
+
+			zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+			require.NoError(t, err)
+			files := map[string][]byte{}
+			for _, f := range zr.File {
+				rc, err := f.Open()
+				require.NoError(t, err)
+				files[f.Name], err = io.ReadAll(rc)
+				require.NoError(t, err)
+				rc.Close()
+			}
+			assert.Len(t, files, 8)
+
+			var profile map[string]any
+			require.NoError(t, json.Unmarshal(files["profile.json"], &profile))
+			assert.Equal(t, "alice", profile["username"])
+			assert.NotContains(t, profile, "password")
+
+			var workouts []store.Workout
+			require.NoError(t, json.Unmarshal(files["workouts.json"], &workouts))
+			require.Len(t, workouts, 1)
+			assert.Equal(t, "Legs", workouts[0].Title)
+			assert.Len(t, workouts[0].Entries, 1)
+
+			var sessions []store.Session
+			require.NoError(t, json.Unmarshal(files["sessions.json"], &sessions))
+			require.Len(t, sessions, 1)
+			assert.True(t, sessions[0].Current)
+
+			var apiKeys []map[string]any
+			require.NoError(t, json.Unmarshal(files["api_keys.json"], &apiKeys))
+			require.Len(t, apiKeys, 1)
+			assert.Equal(t, "script", apiKeys[0]["name"])
+			assert.NotContains(t, apiKeys[0], "hash")
+		})
+	}
+}
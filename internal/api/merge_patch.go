@@ -0,0 +1,67 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+	"github.com/alireza-akbarzadeh/fem_project/internal/validation"
+)
+
+// readMergePatch reads an RFC 7396 merge patch from the request body. It
+// writes an error response and returns false unless the body is a JSON
+// object that leaves the readOnly members alone.
+func readMergePatch(w http.ResponseWriter, r *http.Request, logger *log.Logger, readOnly []string) ([]byte, map[string]json.RawMessage, bool) {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/merge-patch+json" && mediaType != "application/json") {
+			utils.WriteJSON(w, http.StatusUnsupportedMediaType, utils.Envelope{"error": "content type must be application/merge-patch+json"})
+			return nil, nil, false
+		}
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Printf("failed to read merge patch:%v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return nil, nil, false
+	}
+	var members map[string]json.RawMessage
+	err = json.Unmarshal(patch, &members)
+	if err != nil || members == nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "request body must be a JSON object"})
+		return nil, nil, false
+	}
+
+	v := validation.New()
+	for _, field := range readOnly {
+		if _, ok := members[field]; ok {
+			v.AddError(field, "is read-only")
+		}
+	}
+	if !v.Valid() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body", "errors": v.Errors})
+		return nil, nil, false
+	}
+	return patch, members, true
+}
+
+// mergeInto applies patch to the JSON form of current and decodes the result
+// into dst, rejecting members dst does not know.
+func mergeInto(current any, patch []byte, dst any) error {
+	document, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	merged, err := utils.MergePatch(document, patch)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
+	return dec.Decode(dst)
+}
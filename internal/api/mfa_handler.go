@@ -0,0 +1,232 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/totp"
+	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+)
+
+const totpIssuer = "Fem Project"
+
+type MFAHandler struct {
+	totpStore         store.TOTPStore
+	loginAttemptStore store.LoginAttemptStore
+	logger            *log.Logger
+}
+
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func NewMFAHandler(totpStore store.TOTPStore, loginAttemptStore store.LoginAttemptStore, logger *log.Logger) *MFAHandler {
+	return &MFAHandler{
+		totpStore:         totpStore,
+		loginAttemptStore: loginAttemptStore,
+		logger:            logger,
+	}
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery
+// code. Accepted codes are consumed so they cannot be replayed.
+func verifySecondFactor(totpStore store.TOTPStore, t *store.TOTP, code string) (bool, error) {
+	if step, ok := totp.Validate(t.Secret, code, time.Now()); ok {
+		return totpStore.UseStep(t.UserID, step)
+	}
+	if len(code) == totp.Digits {
+		return false, nil
+	}
+	return totpStore.UseRecoveryCode(t.UserID, totp.HashRecoveryCode(code))
+}
+
+// @Summary      Start TOTP enrollment
+// @Description  Generates a new TOTP secret for the current user. The returned otpauth URI can be
+// @Description  rendered as a QR code. Two-factor login is enabled once the secret is confirmed.
+// @Tags         MFA
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Success      201 {object} utils.Envelope "Returns the secret and otpauth URI"
+// @Failure      409 {object} utils.Envelope "Two-factor authentication is already enabled"
+// @Failure      500 {object} utils.Envelope "Server error while generating the secret"
+//
+// @Router       /users/me/totp [post]
+func (mh *MFAHandler) HandleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+	existing, err := mh.totpStore.GetTOTP(currentUser.ID)
+	if err != nil {
+		mh.logger.Printf("failed to get totp: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if existing.Enabled() {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		mh.logger.Printf("failed to generate totp secret: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	err = mh.totpStore.SaveUnconfirmedTOTP(currentUser.ID, secret)
+	if err != nil {
+		mh.logger.Printf("failed to save totp secret: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, currentUser.Username, secret),
+	})
+}
+
+// @Summary      Confirm TOTP enrollment
+// @Description  Enables two-factor login after checking a code from the authenticator app.
+// @Description  Returns one-time recovery codes, which are only shown once.
+// @Tags         MFA
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param code body totpCodeRequest true "Code from the authenticator app"
+//
+// @Success      200 {object} utils.Envelope "Returns the recovery codes"
+// @Failure      400 {object} utils.Envelope "No pending enrollment or invalid code"
+// @Failure      500 {object} utils.Envelope "Server error while confirming enrollment"
+//
+// @Router       /users/me/totp/confirm [post]
+func (mh *MFAHandler) HandleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req totpCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	pending, err := mh.totpStore.GetTOTP(currentUser.ID)
+	if err != nil {
+		mh.logger.Printf("failed to get totp: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if pending == nil || pending.Enabled() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "no pending two-factor enrollment"})
+		return
+	}
+	step, ok := totp.Validate(pending.Secret, req.Code, time.Now())
+	if !ok {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid authentication code"})
+		return
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(totp.RecoveryCodeCount)
+	if err != nil {
+		mh.logger.Printf("failed to generate recovery codes: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	hashes := make([][]byte, len(codes))
+	for i, code := range codes {
+		hashes[i] = totp.HashRecoveryCode(code)
+	}
+	err = mh.totpStore.ConfirmTOTP(currentUser.ID, step, hashes)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "no pending two-factor enrollment"})
+		return
+	}
+	if err != nil {
+		mh.logger.Printf("failed to confirm totp: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"recovery_codes": codes})
+}
+
+// @Summary      Disable TOTP
+// @Description  Turns off two-factor login. Requires a current code or a recovery code. Wrong codes
+// @Description  count towards the account lockout.
+// @Tags         MFA
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param code body totpCodeRequest true "Authenticator or recovery code"
+//
+// @Success      204 "Two-factor authentication disabled"
+// @Failure      400 {object} utils.Envelope "Invalid code or not enabled"
+// @Failure      429 {object} utils.Envelope "Too many failed attempts, see Retry-After"
+// @Failure      500 {object} utils.Envelope "Server error while disabling two-factor authentication"
+//
+// @Router       /users/me/totp [delete]
+func (mh *MFAHandler) HandleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req totpCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	existing, err := mh.totpStore.GetTOTP(currentUser.ID)
+	if err != nil {
+		mh.logger.Printf("failed to get totp: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if !existing.Enabled() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "two-factor authentication is not enabled"})
+		return
+	}
+
+	// wrong codes count towards the same lockout as failed logins, so a
+	// stolen session cannot be used to guess codes
+	userKey := userAttemptKey(currentUser.Username)
+	until, err := lockedUntil(mh.loginAttemptStore, userKey)
+	if err != nil {
+		mh.logger.Printf("failed to check login lockout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if time.Now().Before(until) {
+		writeLockedOut(w, until)
+		return
+	}
+	ok, err := verifySecondFactor(mh.totpStore, existing, req.Code)
+	if err != nil {
+		mh.logger.Printf("failed to verify second factor: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if !ok {
+		err = recordFailure(mh.loginAttemptStore, userKey, maxUserFailures)
+		if err != nil {
+			mh.logger.Printf("failed to record login failure: %v", err)
+		}
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid authentication code"})
+		return
+	}
+	err = mh.loginAttemptStore.Reset(userKey)
+	if err != nil {
+		mh.logger.Printf("failed to reset login attempts: %v", err)
+	}
+
+	err = mh.totpStore.DeleteTOTP(currentUser.ID)
+	if err != nil {
+		mh.logger.Printf("failed to delete totp: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,321 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
+	"github.com/alireza-akbarzadeh/fem_project/internal/totp"
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+// fakeTOTPStore keeps one TOTP per user and the unused recovery code hashes
+// of every user.
+type fakeTOTPStore struct {
+	store.TOTPStore
+	totps    map[int]*store.TOTP
+	recovery map[string]int
+}
+
+func (f *fakeTOTPStore) GetTOTP(userID int) (*store.TOTP, error) {
+	return f.totps[userID], nil
+}
+
+func (f *fakeTOTPStore) SaveUnconfirmedTOTP(userID int, secret string) error {
+	f.totps[userID] = &store.TOTP{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (f *fakeTOTPStore) ConfirmTOTP(userID int, step int64, recoveryCodeHashes [][]byte) error {
+	pending := f.totps[userID]
+	if pending == nil || pending.Enabled() {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	pending.ConfirmedAt = &now
+	pending.LastUsedStep = step
+	for _, hash := range recoveryCodeHashes {
+		f.recovery[string(hash)] = userID
+	}
+	return nil
+}
+
+func (f *fakeTOTPStore) UseStep(userID int, step int64) (bool, error) {
+	t := f.totps[userID]
+	if t.LastUsedStep >= step {
+		return false, nil
+	}
+	t.LastUsedStep = step
+	return true, nil
+}
+
+func (f *fakeTOTPStore) UseRecoveryCode(userID int, codeHash []byte) (bool, error) {
+	owner, ok := f.recovery[string(codeHash)]
+	if !ok || owner != userID {
+		return false, nil
+	}
+	delete(f.recovery, string(codeHash))
+	return true, nil
+}
+
+func (f *fakeTOTPStore) DeleteTOTP(userID int) error {
+	delete(f.totps, userID)
+	return nil
+}
+
+// newEnabledTOTP returns a confirmed TOTP for user 1 with the recovery code
+// "abcde-fghij".
+func newEnabledTOTP(t *testing.T) *fakeTOTPStore {
+	t.Helper()
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	confirmed := time.Now().Add(-time.Hour)
+	return &fakeTOTPStore{
+		totps:    map[int]*store.TOTP{1: {UserID: 1, Secret: secret, ConfirmedAt: &confirmed}},
+		recovery: map[string]int{string(totp.HashRecoveryCode("abcde-fghij")): 1},
+	}
+}
+
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totp.GenerateCode(secret, time.Now())
+	require.NoError(t, err)
+	return code
+}
+
+func TestHandleEnrollTOTP(t *testing.T) {
+	tests := []struct {
+		name           string
+		enabled        bool
+		pending        bool
+		expectedStatus int
+	}{
+		{name: "new enrollment", expectedStatus: http.StatusCreated},
+		{name: "restarts a pending enrollment", pending: true, expectedStatus: http.StatusCreated},
+		{name: "already enabled", enabled: true, expectedStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totpStore := &fakeTOTPStore{totps: map[int]*store.TOTP{}}
+			if tt.enabled {
+				totpStore = newEnabledTOTP(t)
+			}
+			if tt.pending {
+				totpStore.totps[1] = &store.TOTP{UserID: 1, Secret: "OLDSECRET"}
+			}
+			before := totpStore.totps[1]
+			mh := NewMFAHandler(totpStore, newFakeLoginAttemptStore(), log.New(io.Discard, "", 0))
+
+			req := httptest.NewRequest(http.MethodPost, "/users/me/totp", nil)
+			req = middleware.SetUser(req, &store.User{ID: 1, Username: "alice"})
+			rr := httptest.NewRecorder()
+			mh.HandleEnrollTOTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedStatus != http.StatusCreated {
+				assert.Same(t, before, totpStore.totps[1])
+				return
+			}
+			var body struct {
+				Secret     string `json:"secret"`
+				OTPAuthURI string `json:"otpauth_uri"`
+			}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+			assert.Equal(t, body.Secret, totpStore.totps[1].Secret)
+			assert.False(t, totpStore.totps[1].Enabled())
+			assert.Equal(t, totp.URI(totpIssuer, "alice", body.Secret), body.OTPAuthURI)
+		})
+	}
+}
+
+func TestHandleConfirmTOTP(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		code           string
+		pending        bool
+		enabled        bool
+		expectedStatus int
+		expectedError  string
+	}{
+		{name: "valid code", code: currentCode(t, secret), pending: true, expectedStatus: http.StatusOK},
+		{name: "wrong code", code: "000000", pending: true, expectedStatus: http.StatusBadRequest, expectedError: "invalid authentication code"},
+		{name: "short code", code: "12345", pending: true, expectedStatus: http.StatusBadRequest, expectedError: "invalid authentication code"},
+		{name: "no pending enrollment", code: currentCode(t, secret), expectedStatus: http.StatusBadRequest, expectedError: "no pending two-factor enrollment"},
+		{name: "already enabled", code: currentCode(t, secret), enabled: true, expectedStatus: http.StatusBadRequest, expectedError: "no pending two-factor enrollment"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totpStore := &fakeTOTPStore{totps: map[int]*store.TOTP{}, recovery: map[string]int{}}
+			if tt.pending || tt.enabled {
+				totpStore.totps[1] = &store.TOTP{UserID: 1, Secret: secret}
+			}
+			if tt.enabled {
+				confirmed := time.Now().Add(-time.Hour)
+				totpStore.totps[1].ConfirmedAt = &confirmed
+			}
+			mh := NewMFAHandler(totpStore, newFakeLoginAttemptStore(), log.New(io.Discard, "", 0))
+
+			req := httptest.NewRequest(http.MethodPost, "/users/me/totp/confirm", strings.NewReader(`{"code":"`+tt.code+`"}`))
+			req = middleware.SetUser(req, &store.User{ID: 1, Username: "alice"})
+			rr := httptest.NewRecorder()
+			mh.HandleConfirmTOTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedError != "" {
+				assert.JSONEq(t, `{"error":"`+tt.expectedError+`"}`, rr.Body.String())
+				assert.Empty(t, totpStore.recovery)
+				return
+			}
+			var body struct {
+				RecoveryCodes []string `json:"recovery_codes"`
+			}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+			assert.Len(t, body.RecoveryCodes, totp.RecoveryCodeCount)
+			assert.Len(t, totpStore.recovery, totp.RecoveryCodeCount)
+			assert.True(t, totpStore.totps[1].Enabled())
+		})
+	}
+}
+
+func TestHandleDisableTOTP(t *testing.T) {
+	tests := []struct {
+		name            string
+		code            func(secret string) string
+		notEnabled      bool
+		replayed        bool
+		expectedStatus  int
+		expectedEnabled bool
+	}{
+		{name: "current code", code: func(secret string) string { return currentCode(t, secret) }, expectedStatus: http.StatusNoContent},
+		{name: "recovery code", code: func(string) string { return "ABCDE-FGHIJ" }, expectedStatus: http.StatusNoContent},
+		{name: "wrong code", code: func(string) string { return "000000" }, expectedStatus: http.StatusBadRequest, expectedEnabled: true},
+		{name: "unknown recovery code", code: func(string) string { return "zzzzz-zzzzz" }, expectedStatus: http.StatusBadRequest, expectedEnabled: true},
+		{name: "replayed code", code: func(secret string) string { return currentCode(t, secret) }, replayed: true, expectedStatus: http.StatusBadRequest, expectedEnabled: true},
+		{name: "not enabled", code: func(secret string) string { return currentCode(t, secret) }, notEnabled: true, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totpStore := newEnabledTOTP(t)
+			secret := totpStore.totps[1].Secret
+			if tt.replayed {
+				totpStore.totps[1].LastUsedStep = totp.Step(time.Now()) + totp.Skew
+			}
+			if tt.notEnabled {
+				totpStore.totps[1].ConfirmedAt = nil
+			}
+			mh := NewMFAHandler(totpStore, newFakeLoginAttemptStore(), log.New(io.Discard, "", 0))
+
+			req := httptest.NewRequest(http.MethodDelete, "/users/me/totp", strings.NewReader(`{"code":"`+tt.code(secret)+`"}`))
+			req = middleware.SetUser(req, &store.User{ID: 1, Username: "alice"})
+			rr := httptest.NewRecorder()
+			mh.HandleDisableTOTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedEnabled, totpStore.totps[1].Enabled())
+		})
+	}
+}
+
+func TestHandleDisableTOTPLockout(t *testing.T) {
+	totpStore := newEnabledTOTP(t)
+	secret := totpStore.totps[1].Secret
+	attempts := newFakeLoginAttemptStore()
+	mh := NewMFAHandler(totpStore, attempts, log.New(io.Discard, "", 0))
+
+	disable := func(code string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/users/me/totp", strings.NewReader(`{"code":"`+code+`"}`))
+		req = middleware.SetUser(req, &store.User{ID: 1, Username: "alice"})
+		rr := httptest.NewRecorder()
+		mh.HandleDisableTOTP(rr, req)
+		return rr.Code
+	}
+
+	for i := 0; i < maxUserFailures; i++ {
+		assert.Equal(t, http.StatusBadRequest, disable("000000"))
+	}
+	assert.Equal(t, maxUserFailures, attempts.failures["user:alice"])
+	assert.Equal(t, http.StatusTooManyRequests, disable(currentCode(t, secret)), "even a correct code is refused while locked")
+	assert.True(t, totpStore.totps[1].Enabled())
+
+	delete(attempts.lockedUntil, "user:alice")
+	assert.Equal(t, http.StatusNoContent, disable(currentCode(t, secret)))
+	assert.Zero(t, attempts.failures["user:alice"], "success resets the counter")
+}
+
+func TestHandleCompleteMFA(t *testing.T) {
+	tests := []struct {
+		name             string
+		mfaToken         string
+		code             func(secret string) string
+		replayed         bool
+		locked           bool
+		expectedStatus   int
+		expectedFailures int
+	}{
+		{name: "current code", mfaToken: "pending", code: func(secret string) string { return currentCode(t, secret) }, expectedStatus: http.StatusCreated},
+		{name: "recovery code", mfaToken: "pending", code: func(string) string { return "abcde-fghij" }, expectedStatus: http.StatusCreated},
+		{name: "wrong code", mfaToken: "pending", code: func(string) string { return "000000" }, expectedStatus: http.StatusUnauthorized, expectedFailures: 1},
+		{name: "replayed code", mfaToken: "pending", code: func(secret string) string { return currentCode(t, secret) }, replayed: true, expectedStatus: http.StatusUnauthorized, expectedFailures: 1},
+		{name: "expired mfa token", mfaToken: "expired", code: func(secret string) string { return currentCode(t, secret) }, expectedStatus: http.StatusUnauthorized},
+		{name: "access token instead of mfa token", mfaToken: "access", code: func(secret string) string { return currentCode(t, secret) }, expectedStatus: http.StatusUnauthorized},
+		{name: "locked account", mfaToken: "pending", code: func(secret string) string { return currentCode(t, secret) }, locked: true, expectedStatus: http.StatusTooManyRequests},
+		{name: "missing code", mfaToken: "pending", code: func(string) string { return "" }, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totpStore := newEnabledTOTP(t)
+			secret := totpStore.totps[1].Secret
+			if tt.replayed {
+				totpStore.totps[1].LastUsedStep = totp.Step(time.Now()) + totp.Skew
+			}
+			tokenStore := &fakeTokenStore{}
+			tokenStore.add("pending", 1, tokens.ScopeMFAPending, "")
+			tokenStore.add("expired", 1, tokens.ScopeMFAPending, "").Expiry = time.Now().Add(-time.Minute)
+			tokenStore.add("access", 1, tokens.ScopeAuth, "other")
+			attempts := newFakeLoginAttemptStore()
+			if tt.locked {
+				attempts.lockedUntil["user:alice"] = time.Now().Add(time.Minute)
+			}
+			th := &TokenHandler{
+				tokenStore:        tokenStore,
+				userStore:         &fakeUserStore{users: map[int]*store.User{1: {ID: 1, Username: "alice"}}, tokenStore: tokenStore},
+				loginAttemptStore: attempts,
+				totpStore:         totpStore,
+				logger:            log.New(io.Discard, "", 0),
+			}
+
+			body := `{"mfa_token":"` + tt.mfaToken + `","code":"` + tt.code(secret) + `"}`
+			req := httptest.NewRequest(http.MethodPost, "/tokens/mfa", strings.NewReader(body))
+			rr := httptest.NewRecorder()
+			th.HandleCompleteMFA(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedFailures, attempts.failures["user:alice"])
+			if tt.expectedStatus == http.StatusCreated {
+				assert.Nil(t, tokenStore.lookup(tokens.ScopeMFAPending, "pending"), "the mfa token is single use")
+				var issued struct {
+					AuthToken tokens.Token `json:"auth_token"`
+				}
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&issued))
+				assert.NotNil(t, tokenStore.lookup(tokens.ScopeAuth, issued.AuthToken.Plaintext))
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/mailer"
+	"github.com/alireza-akbarzadeh/fem_project/internal/passwords"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
+	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+	"github.com/alireza-akbarzadeh/fem_project/internal/validation"
+)
+
+type PasswordHandler struct {
+	userStore  store.UserStore
+	tokenStore store.TokenStore
+	mailer     mailer.Mailer
+	logger     *log.Logger
+	Policy     *validation.PasswordPolicy
+}
+
+type requestPasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func NewPasswordHandler(userStore store.UserStore, tokenStore store.TokenStore, mailer mailer.Mailer, logger *log.Logger) *PasswordHandler {
+	return &PasswordHandler{
+		userStore:  userStore,
+		tokenStore: tokenStore,
+		mailer:     mailer,
+		logger:     logger,
+		Policy:     validation.DefaultPasswordPolicy(),
+	}
+}
+
+// checkNewPassword returns the policy rules newPassword breaks for user,
+// including reuse of the current password or one of the previous
+// policy.HistorySize-1 passwords.
+func checkNewPassword(userStore store.UserStore, policy *validation.PasswordPolicy, user *store.User, newPassword string) ([]string, error) {
+	failures := policy.Validate(newPassword)
+	if policy.HistorySize <= 0 {
+		return failures, nil
+	}
+
+	previous := [][]byte{user.PasswordHash.Hash}
+	if policy.HistorySize > 1 {
+		history, err := userStore.GetPasswordHistory(user.ID, policy.HistorySize-1)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, history...)
+	}
+	for _, hash := range previous {
+		matches, err := passwords.Verify(newPassword, hash)
+		if errors.Is(err, passwords.ErrUnknownFormat) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			failures = append(failures, fmt.Sprintf("must not match any of your last %d passwords", policy.HistorySize))
+			break
+		}
+	}
+	return failures, nil
+}
+
+func writePasswordPolicyFailures(w http.ResponseWriter, failures []string) {
+	utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{
+		"error":  "password does not meet the password policy",
+		"errors": utils.Envelope{"password": failures},
+	})
+}
+
+// @Summary      Request a password reset
+// @Description  Issues a single-use password reset token for the account with the given email.
+// @Description  The response is the same whether or not the email is registered.
+// @Tags         Password
+// @Accept       json
+// @Produce      json
+//
+// @Param request body requestPasswordResetRequest true "Account email"
+//
+// @Success      202 {object} utils.Envelope "Reset instructions will be sent if the account exists"
+// @Failure      400 {object} utils.Envelope "Invalid input or bad request"
+// @Failure      500 {object} utils.Envelope "Server error while issuing the token"
+//
+// @Router       /password-reset [post]
+func (ph *PasswordHandler) HandleRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req requestPasswordResetRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ph.logger.Printf("failed to decode password reset request: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	req.Email = validation.NormalizeEmail(req.Email)
+	if !validation.IsEmailValid(req.Email) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid email format"})
+		return
+	}
+
+	accepted := utils.Envelope{"message": "if an account with that email exists, password reset instructions have been sent"}
+
+	user, err := ph.userStore.GetUserByEmail(req.Email)
+	if err != nil {
+		ph.logger.Printf("failed to get user by email: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+
+	// only the most recently requested reset token stays valid
+	err = ph.tokenStore.DeleteAllTokensForUser(user.ID, tokens.ScopePasswordReset)
+	if err != nil {
+		ph.logger.Printf("failed to delete old password reset tokens: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	token, err := ph.tokenStore.CreateNewToken(user.ID, tokens.PasswordResetTokenTTL, tokens.ScopePasswordReset)
+	if err != nil {
+		ph.logger.Printf("failed to create password reset token: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	// send in the background so the response time does not reveal whether the account exists
+	go func() {
+		data := map[string]any{
+			"Username": user.Username,
+			"Token":    token.Plaintext,
+			"Expiry":   token.Expiry.Format(time.RFC1123),
+		}
+		err := ph.mailer.Send(user.Email, "password_reset.tmpl", data)
+		if err != nil {
+			ph.logger.Printf("failed to send password reset email: %v", err)
+		}
+	}()
+
+	utils.WriteJSON(w, http.StatusAccepted, accepted)
+}
+
+// @Summary      Reset a password
+// @Description  Sets a new password using a password reset token. The token is consumed and
+// @Description  every existing session for the account is signed out.
+// @Tags         Password
+// @Accept       json
+// @Produce      json
+//
+// @Param request body resetPasswordRequest true "Reset token and new password"
+//
+// @Success      200 {object} utils.Envelope "Password updated"
+// @Failure      400 {object} utils.Envelope "Invalid input or token, or the password breaks the password policy"
+// @Failure      500 {object} utils.Envelope "Server error while resetting the password"
+//
+// @Router       /password-reset [put]
+func (ph *PasswordHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ph.logger.Printf("failed to decode reset password request: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	if req.Token == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "token is required"})
+		return
+	}
+
+	user, err := ph.userStore.GetUserToken(tokens.ScopePasswordReset, req.Token)
+	if err != nil {
+		ph.logger.Printf("failed to get user by reset token: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid or expired password reset token"})
+		return
+	}
+
+	failures, err := checkNewPassword(ph.userStore, ph.Policy, user, req.Password)
+	if err != nil {
+		ph.logger.Printf("failed to check password history: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if len(failures) > 0 {
+		writePasswordPolicyFailures(w, failures)
+		return
+	}
+
+	err = user.PasswordHash.Set(req.Password)
+	if err != nil {
+		ph.logger.Printf("failed to hash password: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to process password"})
+		return
+	}
+	err = ph.userStore.ChangePassword(user.ID, user.PasswordHash.Hash, ph.Policy.HistorySize)
+	if err != nil {
+		ph.logger.Printf("failed to update password: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update password"})
+		return
+	}
+
+	for _, scope := range []string{tokens.ScopePasswordReset, tokens.ScopeAuth, tokens.ScopeRefresh} {
+		err = ph.tokenStore.DeleteAllTokensForUser(user.ID, scope)
+		if err != nil {
+			ph.logger.Printf("failed to revoke %s tokens: %v", scope, err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "password updated successfully"})
+}
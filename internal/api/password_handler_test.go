@@ -0,0 +1,324 @@
+package api
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/passwords"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
+	"github.com/alireza-akbarzadeh/fem_project/internal/validation"
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+type fakeUserStore struct {
+	store.UserStore
+	history    [][]byte
+	updated    *store.User
+	taken      map[string]bool
+	users      map[int]*store.User
+	tokenStore *fakeTokenStore
+}
+
+func (f *fakeUserStore) GetUserByUserName(username string) (*store.User, error) {
+	for _, user := range f.users {
+		if strings.EqualFold(user.Username, username) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUserStore) UpdatePassword(userID int, passwordHash []byte) error {
+	f.users[userID].PasswordHash.Hash = passwordHash
+	return nil
+}
+
+func (f *fakeUserStore) ScheduleDeletion(userID int, at time.Time) error {
+	user, ok := f.users[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	user.DeletionScheduledFor = &at
+	return nil
+}
+
+func (f *fakeUserStore) CancelDeletion(userID int) error {
+	f.users[userID].DeletionScheduledFor = nil
+	return nil
+}
+
+func (f *fakeUserStore) GetUserByEmail(email string) (*store.User, error) {
+	for _, user := range f.users {
+		if strings.EqualFold(user.Email, email) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUserStore) GetUserToken(scope, tokenPlainText string) (*store.User, error) {
+	token := f.tokenStore.lookup(scope, tokenPlainText)
+	if token == nil {
+		return nil, nil
+	}
+	found := *f.users[token.UserID]
+	return &found, nil
+}
+
+func (f *fakeUserStore) ChangePassword(userID int, passwordHash []byte, keep int) error {
+	user := f.users[userID]
+	f.history = append([][]byte{user.PasswordHash.Hash}, f.history...)
+	f.history = f.history[:min(keep, len(f.history))]
+	user.PasswordHash.Hash = passwordHash
+	return nil
+}
+
+func (f *fakeUserStore) UpdateUser(user *store.User) error {
+	if f.taken[strings.ToLower(user.Username)] {
+		return store.ErrDuplicateUsername
+	}
+	f.updated = user
+	return nil
+}
+
+func (f *fakeUserStore) GetUserByID(id int64) (*store.User, error) {
+	return f.users[int(id)], nil
+}
+
+func (f *fakeUserStore) SetAvatarKey(userID int, key string) error {
+	f.users[userID].AvatarKey = key
+	return nil
+}
+
+func (f *fakeUserStore) IsUsernameTaken(username string) (bool, error) {
+	return f.taken[strings.ToLower(username)], nil
+}
+
+func (f *fakeUserStore) GetPasswordHistory(userID int, limit int) ([][]byte, error) {
+	return f.history[:min(limit, len(f.history))], nil
+}
+
+type sentMail struct {
+	recipient string
+	template  string
+	data      map[string]any
+}
+
+type fakeMailer struct {
+	sent chan sentMail
+}
+
+func (m *fakeMailer) Send(recipient, templateFile string, data any) error {
+	m.sent <- sentMail{recipient: recipient, template: templateFile, data: data.(map[string]any)}
+	return nil
+}
+
+func TestCheckNewPassword(t *testing.T) {
+	hash := func(plaintext string) []byte {
+		h, err := passwords.HashWithParams(plaintext, passwords.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+		require.NoError(t, err)
+		return h
+	}
+
+	user := &store.User{ID: 1}
+	user.PasswordHash.Hash = hash("Current-Pass1")
+	userStore := &fakeUserStore{history: [][]byte{hash("Previous-Pass1"), hash("Ancient-Pass1")}}
+
+	policy := validation.DefaultPasswordPolicy()
+	policy.HistorySize = 2
+
+	tests := []struct {
+		name     string
+		password string
+		expected []string
+	}{
+		{name: "new password", password: "Brand-New-Pass1", expected: nil},
+		{name: "current password", password: "Current-Pass1", expected: []string{"must not match any of your last 2 passwords"}},
+		{name: "within history", password: "Previous-Pass1", expected: []string{"must not match any of your last 2 passwords"}},
+		{name: "older than history", password: "Ancient-Pass1", expected: nil},
+		{name: "breaks policy", password: "short", expected: []string{
+			"must be at least 8 characters long",
+			"must contain an uppercase letter",
+			"must contain a number",
+			"must contain a special character",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failures, err := checkNewPassword(userStore, policy, user, tt.password)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, failures)
+		})
+	}
+}
+
+func TestHandleRequestPasswordReset(t *testing.T) {
+	tests := []struct {
+		name              string
+		body              string
+		expectedStatus    int
+		expectedRecipient string
+		expectedRemaining []string
+	}{
+		{name: "registered email", body: `{"email":"Alice@Example.com"}`, expectedStatus: http.StatusAccepted, expectedRecipient: "alice@example.com", expectedRemaining: []string{"alice-access", "bob-reset"}},
+		{name: "unknown email", body: `{"email":"carol@example.com"}`, expectedStatus: http.StatusAccepted, expectedRemaining: []string{"alice-reset", "alice-access", "bob-reset"}},
+		{name: "invalid email", body: `{"email":"nope"}`, expectedStatus: http.StatusBadRequest, expectedRemaining: []string{"alice-reset", "alice-access", "bob-reset"}},
+		{name: "malformed body", body: `{"email":`, expectedStatus: http.StatusBadRequest, expectedRemaining: []string{"alice-reset", "alice-access", "bob-reset"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenStore := &fakeTokenStore{}
+			tokenStore.add("alice-reset", 1, tokens.ScopePasswordReset, "")
+			tokenStore.add("alice-access", 1, tokens.ScopeAuth, "alice")
+			tokenStore.add("bob-reset", 2, tokens.ScopePasswordReset, "")
+			userStore := &fakeUserStore{users: map[int]*store.User{
+				1: {ID: 1, Username: "alice", Email: "alice@example.com"},
+				2: {ID: 2, Username: "bob", Email: "bob@example.com"},
+			}}
+			mailer := &fakeMailer{sent: make(chan sentMail, 1)}
+			ph := NewPasswordHandler(userStore, tokenStore, mailer, log.New(io.Discard, "", 0))
+
+			req := httptest.NewRequest(http.MethodPost, "/password-reset", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			ph.HandleRequestPasswordReset(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedRecipient == "" {
+				assert.Equal(t, tt.expectedRemaining, tokenStore.remaining())
+				return
+			}
+			select {
+			case mail := <-mailer.sent:
+				assert.Equal(t, tt.expectedRecipient, mail.recipient)
+				assert.Equal(t, "password_reset.tmpl", mail.template)
+				token := tokenStore.lookup(tokens.ScopePasswordReset, mail.data["Token"].(string))
+				require.NotNil(t, token)
+				assert.Equal(t, 1, token.UserID)
+				assert.Equal(t, append(tt.expectedRemaining, mail.data["Token"].(string)), tokenStore.remaining())
+			case <-time.After(time.Second):
+				t.Fatal("no password reset email was sent")
+			}
+		})
+	}
+}
+
+func TestHandleResetPassword(t *testing.T) {
+	tests := []struct {
+		name              string
+		body              string
+		expectedStatus    int
+		expectedPassword  string
+		expectedRemaining []string
+	}{
+		{
+			name:              "valid token",
+			body:              `{"token":"alice-reset","password":"Brand-New-Pass1"}`,
+			expectedStatus:    http.StatusOK,
+			expectedPassword:  "Brand-New-Pass1",
+			expectedRemaining: []string{"bob-access"},
+		},
+		{
+			name:              "expired token",
+			body:              `{"token":"expired-reset","password":"Brand-New-Pass1"}`,
+			expectedStatus:    http.StatusBadRequest,
+			expectedPassword:  "Current-Pass1",
+			expectedRemaining: []string{"alice-reset", "expired-reset", "alice-access", "alice-refresh", "bob-access"},
+		},
+		{
+			name:              "unknown token",
+			body:              `{"token":"unknown","password":"Brand-New-Pass1"}`,
+			expectedStatus:    http.StatusBadRequest,
+			expectedPassword:  "Current-Pass1",
+			expectedRemaining: []string{"alice-reset", "expired-reset", "alice-access", "alice-refresh", "bob-access"},
+		},
+		{
+			name:              "wrong scope",
+			body:              `{"token":"alice-access","password":"Brand-New-Pass1"}`,
+			expectedStatus:    http.StatusBadRequest,
+			expectedPassword:  "Current-Pass1",
+			expectedRemaining: []string{"alice-reset", "expired-reset", "alice-access", "alice-refresh", "bob-access"},
+		},
+		{
+			name:              "missing token",
+			body:              `{"password":"Brand-New-Pass1"}`,
+			expectedStatus:    http.StatusBadRequest,
+			expectedPassword:  "Current-Pass1",
+			expectedRemaining: []string{"alice-reset", "expired-reset", "alice-access", "alice-refresh", "bob-access"},
+		},
+		{
+			name:              "breaks policy",
+			body:              `{"token":"alice-reset","password":"short"}`,
+			expectedStatus:    http.StatusBadRequest,
+			expectedPassword:  "Current-Pass1",
+			expectedRemaining: []string{"alice-reset", "expired-reset", "alice-access", "alice-refresh", "bob-access"},
+		},
+		{
+			name:              "reuses current password",
+			body:              `{"token":"alice-reset","password":"Current-Pass1"}`,
+			expectedStatus:    http.StatusBadRequest,
+			expectedPassword:  "Current-Pass1",
+			expectedRemaining: []string{"alice-reset", "expired-reset", "alice-access", "alice-refresh", "bob-access"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenStore := &fakeTokenStore{}
+			tokenStore.add("alice-reset", 1, tokens.ScopePasswordReset, "")
+			tokenStore.add("expired-reset", 1, tokens.ScopePasswordReset, "").Expiry = time.Now().Add(-time.Minute)
+			tokenStore.add("alice-access", 1, tokens.ScopeAuth, "alice")
+			tokenStore.add("alice-refresh", 1, tokens.ScopeRefresh, "alice")
+			tokenStore.add("bob-access", 2, tokens.ScopeAuth, "bob")
+			alice := &store.User{ID: 1, Username: "alice", Email: "alice@example.com"}
+			require.NoError(t, alice.PasswordHash.Set("Current-Pass1"))
+			userStore := &fakeUserStore{users: map[int]*store.User{1: alice}, tokenStore: tokenStore}
+			ph := NewPasswordHandler(userStore, tokenStore, &fakeMailer{}, log.New(io.Discard, "", 0))
+
+			req := httptest.NewRequest(http.MethodPut, "/password-reset", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			ph.HandleResetPassword(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedRemaining, tokenStore.remaining())
+			match, err := alice.PasswordHash.Matches(tt.expectedPassword)
+			require.NoError(t, err)
+			assert.True(t, match)
+		})
+	}
+}
+
+func TestHandleResetPasswordTokenIsSingleUse(t *testing.T) {
+	tokenStore := &fakeTokenStore{}
+	tokenStore.add("alice-reset", 1, tokens.ScopePasswordReset, "")
+	alice := &store.User{ID: 1, Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, alice.PasswordHash.Set("Current-Pass1"))
+	userStore := &fakeUserStore{users: map[int]*store.User{1: alice}, tokenStore: tokenStore}
+	ph := NewPasswordHandler(userStore, tokenStore, &fakeMailer{}, log.New(io.Discard, "", 0))
+
+	reset := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/password-reset", strings.NewReader(`{"token":"alice-reset","password":"`+password+`"}`))
+		rr := httptest.NewRecorder()
+		ph.HandleResetPassword(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusOK, reset("Brand-New-Pass1").Code)
+	rr := reset("Another-New-Pass1")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.JSONEq(t, `{"error":"invalid or expired password reset token"}`, rr.Body.String())
+	match, err := alice.PasswordHash.Matches("Brand-New-Pass1")
+	require.NoError(t, err)
+	assert.True(t, match)
+}
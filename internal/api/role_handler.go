@@ -0,0 +1,153 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type RoleHandler struct {
+	permissionStore store.PermissionStore
+	userStore       store.UserStore
+	logger          *log.Logger
+}
+
+type assignRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func NewRoleHandler(permissionStore store.PermissionStore, userStore store.UserStore, logger *log.Logger) *RoleHandler {
+	return &RoleHandler{
+		permissionStore: permissionStore,
+		userStore:       userStore,
+		logger:          logger,
+	}
+}
+
+// @Summary      List a user's roles
+// @Description  Returns the roles and the resulting permissions held by a user.
+// @Tags         Roles
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param id path int true "User ID"
+//
+// @Success      200 {object} utils.Envelope "Returns roles and permissions"
+// @Failure      400 {object} utils.Envelope "Invalid user ID"
+// @Failure      403 {object} utils.Envelope "Missing users:read permission"
+// @Failure      500 {object} utils.Envelope "Server error while fetching roles"
+//
+// @Router       /users/{id}/roles [get]
+func (rh *RoleHandler) HandleGetUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.ReadIDParams(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user ID"})
+		return
+	}
+	roles, err := rh.permissionStore.GetRolesForUser(int(userID))
+	if err != nil {
+		rh.logger.Printf("failed to get roles for user: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch roles"})
+		return
+	}
+	permissions, err := rh.permissionStore.GetAllForUser(int(userID))
+	if err != nil {
+		rh.logger.Printf("failed to get permissions for user: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch roles"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"roles": roles, "permissions": permissions})
+}
+
+// @Summary      Assign a role to a user
+// @Tags         Roles
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param id   path int               true "User ID"
+// @Param role body assignRoleRequest true "Role name"
+//
+// @Success      200 {object} utils.Envelope "Role assigned"
+// @Failure      400 {object} utils.Envelope "Invalid input or unknown role"
+// @Failure      403 {object} utils.Envelope "Missing roles:assign permission"
+// @Failure      404 {object} utils.Envelope "User not found"
+// @Failure      500 {object} utils.Envelope "Server error while assigning the role"
+//
+// @Router       /users/{id}/roles [post]
+func (rh *RoleHandler) HandleAssignRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.ReadIDParams(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user ID"})
+		return
+	}
+	var req assignRoleRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Role == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	user, err := rh.userStore.GetUserByID(userID)
+	if err != nil {
+		rh.logger.Printf("failed to get user by ID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch user"})
+		return
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "user not found"})
+		return
+	}
+
+	err = rh.permissionStore.AssignRole(user.ID, req.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "unknown role"})
+		return
+	}
+	if err != nil {
+		rh.logger.Printf("failed to assign role: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to assign role"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "role assigned successfully"})
+}
+
+// @Summary      Remove a role from a user
+// @Tags         Roles
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param id   path int    true "User ID"
+// @Param role path string true "Role name"
+//
+// @Success      204 "Role removed"
+// @Failure      400 {object} utils.Envelope "Invalid user ID"
+// @Failure      403 {object} utils.Envelope "Missing roles:assign permission"
+// @Failure      404 {object} utils.Envelope "User does not hold the role"
+// @Failure      500 {object} utils.Envelope "Server error while removing the role"
+//
+// @Router       /users/{id}/roles/{role} [delete]
+func (rh *RoleHandler) HandleRemoveRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.ReadIDParams(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user ID"})
+		return
+	}
+	err = rh.permissionStore.RemoveRole(int(userID), chi.URLParam(r, "role"))
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "user does not have this role"})
+		return
+	}
+	if err != nil {
+		rh.logger.Printf("failed to remove role: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to remove role"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,20 +1,57 @@
 package api
 
 import (
+	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
 	"github.com/alireza-akbarzadeh/fem_project/internal/store"
 	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
 	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+	"github.com/alireza-akbarzadeh/fem_project/internal/validation"
 )
 
+// Failed logins are counted per account and per client address. Once a key
+// reaches its threshold inside failureWindow it is locked, and every further
+// failure doubles the lockout up to maxLockout.
+const (
+	maxUserFailures = 5
+	maxIPFailures   = 20
+	failureWindow   = 15 * time.Minute
+	baseLockout     = time.Minute
+	maxLockout      = time.Hour
+)
+
+// dummyUser carries a real password hash so that unknown usernames take as
+// long to reject as wrong passwords.
+var dummyUser = func() *store.User {
+	user := &store.User{}
+	err := user.PasswordHash.Set("not-a-real-password")
+	if err != nil {
+		panic(fmt.Sprintf("failed to hash dummy password: %v", err))
+	}
+	return user
+}()
+
 type TokenHandler struct {
-	tokenStore store.TokenStore
-	userStore  store.UserStore
-	logger     *log.Logger
+	tokenStore        store.TokenStore
+	userStore         store.UserStore
+	loginAttemptStore store.LoginAttemptStore
+	totpStore         store.TOTPStore
+	logger            *log.Logger
+	// RequireActivation rejects logins from accounts that have not
+	// confirmed their email address yet.
+	RequireActivation bool
 }
 
 type createTokenRequest struct {
@@ -22,14 +59,124 @@ type createTokenRequest struct {
 	Password string `json:"password"`
 }
 
-func NewTokenHandler(tokenStore store.TokenStore, userStore store.UserStore, logger *log.Logger) *TokenHandler {
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type completeMFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+func NewTokenHandler(tokenStore store.TokenStore, userStore store.UserStore, loginAttemptStore store.LoginAttemptStore, totpStore store.TOTPStore, logger *log.Logger) *TokenHandler {
 	return &TokenHandler{
-		tokenStore: tokenStore,
-		userStore:  userStore,
-		logger:     logger,
+		tokenStore:        tokenStore,
+		userStore:         userStore,
+		loginAttemptStore: loginAttemptStore,
+		totpStore:         totpStore,
+		logger:            logger,
 	}
 }
 
+func userAttemptKey(username string) string {
+	return "user:" + strings.ToLower(validation.NormalizeUsername(username))
+}
+
+func ipAttemptKey(r *http.Request) string {
+	return "ip:" + utils.ClientIP(r)
+}
+
+// maxUserAgentLength is the most bytes of a User-Agent header kept with a
+// token.
+const maxUserAgentLength = 255
+
+func clientInfo(r *http.Request) tokens.Client {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		// cut at a rune boundary so a multi-byte character is not split
+		n := maxUserAgentLength
+		for n > 0 && !utf8.RuneStart(userAgent[n]) {
+			n--
+		}
+		userAgent = userAgent[:n]
+	}
+	return tokens.Client{
+		UserAgent: userAgent,
+		IP:        utils.ClientIP(r),
+	}
+}
+
+func lockoutDuration(failures, threshold int) time.Duration {
+	d := baseLockout * time.Duration(math.Pow(2, float64(failures-threshold)))
+	if d <= 0 || d > maxLockout {
+		return maxLockout
+	}
+	return d
+}
+
+// lockedUntil returns the latest lockout across the given keys.
+func lockedUntil(loginAttemptStore store.LoginAttemptStore, keys ...string) (time.Time, error) {
+	var until time.Time
+	for _, key := range keys {
+		t, err := loginAttemptStore.GetLockedUntil(key)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t.After(until) {
+			until = t
+		}
+	}
+	return until, nil
+}
+
+// rehashPassword upgrades the stored hash after a successful login when it was
+// made with an older algorithm or weaker parameters. Failures are only logged
+// since the login itself succeeded.
+func (th *TokenHandler) rehashPassword(user *store.User, plaintext string) {
+	if !user.PasswordHash.NeedsRehash() {
+		return
+	}
+	err := user.PasswordHash.Set(plaintext)
+	if err == nil {
+		err = th.userStore.UpdatePassword(user.ID, user.PasswordHash.Hash)
+	}
+	if err != nil {
+		th.logger.Printf("failed to rehash password:%v", err)
+	}
+}
+
+// cancelScheduledDeletion keeps an account whose owner logs in during the
+// deletion grace period.
+func (th *TokenHandler) cancelScheduledDeletion(user *store.User) error {
+	if user.DeletionScheduledFor == nil {
+		return nil
+	}
+	err := th.userStore.CancelDeletion(user.ID)
+	if err != nil {
+		return err
+	}
+	user.DeletionScheduledFor = nil
+	return nil
+}
+
+func writeLockedOut(w http.ResponseWriter, until time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+	utils.WriteJSON(w, http.StatusTooManyRequests, utils.Envelope{"error": "too many failed login attempts, please try again later"})
+}
+
+// recordFailure counts a failed password or code check against key and locks
+// the key once it reaches threshold.
+func recordFailure(loginAttemptStore store.LoginAttemptStore, key string, threshold int) error {
+	failures, err := loginAttemptStore.RecordFailure(key, failureWindow)
+	if err != nil {
+		return err
+	}
+	if failures < threshold {
+		return nil
+	}
+	return loginAttemptStore.Lock(key, time.Now().Add(lockoutDuration(failures, threshold)))
+}
+
 // @Summary      Register a new user account
 // @Description  Creates a new user in the system after validating the input.
 // @Description  The user must provide a unique username, a valid email address, and a secure password.
@@ -40,8 +187,12 @@ func NewTokenHandler(tokenStore store.TokenStore, userStore store.UserStore, log
 //
 // @Param user body createTokenRequest true "User Params"
 //
-// @Success      201 {object} utils.Envelope{tokens=tokens.Token} "Returns created user information"
+// @Success      200 {object} utils.Envelope{mfa_token=tokens.Token} "Two-factor authentication required, continue with POST /tokens/mfa"
+// @Success      201 {object} utils.Envelope{auth_token=tokens.Token,refresh_token=tokens.Token} "Returns the access and refresh tokens"
 // @Failure      400 {object} utils.Envelope "Invalid input or bad request"
+// @Failure      401 {object} utils.Envelope "Invalid username or password"
+// @Failure      403 {object} utils.Envelope "Account has not been activated"
+// @Failure      429 {object} utils.Envelope "Too many failed attempts, see Retry-After"
 // @Failure      500 {object} utils.Envelope "Server error while creating the user"
 //
 // @Router       /tokens [post]
@@ -53,31 +204,364 @@ func (th *TokenHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
 		return
 	}
-	user, err := th.userStore.GetUserByUserName(req.UserName)
+	userKey, ipKey := userAttemptKey(req.UserName), ipAttemptKey(r)
+	until, err := lockedUntil(th.loginAttemptStore, userKey, ipKey)
+	if err != nil {
+		th.logger.Printf("failed to check login lockout:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if time.Now().Before(until) {
+		writeLockedOut(w, until)
+		return
+	}
+
+	user, err := th.userStore.GetUserByUserName(validation.NormalizeUsername(req.UserName))
 	if err != nil {
 		th.logger.Printf("failed to get user by username:%v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch user"})
 		return
 	}
-	if user == nil {
+	candidate := user
+	if candidate == nil {
+		candidate = dummyUser
+	}
+	match, err := candidate.PasswordHash.Matches(req.Password)
+	if err != nil {
+		th.logger.Printf("failed to check password hash: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if user == nil || !match {
+		err = recordFailure(th.loginAttemptStore, userKey, maxUserFailures)
+		if err == nil {
+			err = recordFailure(th.loginAttemptStore, ipKey, maxIPFailures)
+		}
+		if err != nil {
+			th.logger.Printf("failed to record login failure:%v", err)
+		}
 		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid username or password"})
 		return
 	}
-	match, err := user.PasswordHash.Matches(req.Password)
+	err = th.loginAttemptStore.Reset(userKey)
 	if err != nil {
-		th.logger.Printf("failed to check password hash: %v", err)
+		th.logger.Printf("failed to reset login attempts:%v", err)
+	}
+	th.rehashPassword(user, req.Password)
+	if th.RequireActivation && !user.Activated {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "your account must be activated to log in"})
+		return
+	}
+
+	userTOTP, err := th.totpStore.GetTOTP(user.ID)
+	if err != nil {
+		th.logger.Printf("failed to get totp:%v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
-	if !match {
-		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid credentials"})
+	if userTOTP.Enabled() {
+		mfaToken, err := th.tokenStore.CreateNewToken(user.ID, tokens.MFAPendingTokenTTL, tokens.ScopeMFAPending)
+		if err != nil {
+			th.logger.Printf("failed to create mfa token:%v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create token"})
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"mfa_required": true, "mfa_token": mfaToken})
+		return
+	}
+
+	err = th.cancelScheduledDeletion(user)
+	if err != nil {
+		th.logger.Printf("failed to cancel account deletion:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create token"})
 		return
 	}
-	token, err := th.tokenStore.CreateNewToken(user.ID, 24*time.Hour, tokens.ScopeAuth)
+	accessToken, refreshToken, err := th.tokenStore.CreateTokenPair(user.ID, "", clientInfo(r))
 	if err != nil {
 		th.logger.Printf("failed to create new token:%v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create token"})
 		return
 	}
-	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": token})
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": accessToken, "refresh_token": refreshToken})
+}
+
+// @Summary      Complete a two-factor login
+// @Description  Exchanges the mfa_token from POST /tokens plus an authenticator or recovery code
+// @Description  for an access token and a refresh token.
+// @Tags         Tokens
+// @Accept       json
+// @Produce      json
+//
+// @Param request body completeMFARequest true "MFA token and code"
+//
+// @Success      201 {object} utils.Envelope{auth_token=tokens.Token,refresh_token=tokens.Token} "Returns the access and refresh tokens"
+// @Failure      400 {object} utils.Envelope "Invalid input or bad request"
+// @Failure      401 {object} utils.Envelope "Invalid or expired MFA token, or invalid code"
+// @Failure      429 {object} utils.Envelope "Too many failed attempts, see Retry-After"
+// @Failure      500 {object} utils.Envelope "Server error while creating the token"
+//
+// @Router       /tokens/mfa [post]
+func (th *TokenHandler) HandleCompleteMFA(w http.ResponseWriter, r *http.Request) {
+	var req completeMFARequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.MFAToken == "" || req.Code == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	user, err := th.userStore.GetUserToken(tokens.ScopeMFAPending, req.MFAToken)
+	if err != nil {
+		th.logger.Printf("failed to get user by mfa token:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired mfa token"})
+		return
+	}
+
+	userKey := userAttemptKey(user.Username)
+	until, err := lockedUntil(th.loginAttemptStore, userKey)
+	if err != nil {
+		th.logger.Printf("failed to check login lockout:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if time.Now().Before(until) {
+		writeLockedOut(w, until)
+		return
+	}
+
+	userTOTP, err := th.totpStore.GetTOTP(user.ID)
+	if err != nil {
+		th.logger.Printf("failed to get totp:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	ok := false
+	if userTOTP.Enabled() {
+		ok, err = verifySecondFactor(th.totpStore, userTOTP, req.Code)
+		if err != nil {
+			th.logger.Printf("failed to verify second factor:%v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+	}
+	if !ok {
+		err = recordFailure(th.loginAttemptStore, userKey, maxUserFailures)
+		if err != nil {
+			th.logger.Printf("failed to record login failure:%v", err)
+		}
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid authentication code"})
+		return
+	}
+
+	hash := sha256.Sum256([]byte(req.MFAToken))
+	err = th.tokenStore.DeleteToken(hash[:])
+	if err != nil {
+		th.logger.Printf("failed to delete mfa token:%v", err)
+	}
+	err = th.loginAttemptStore.Reset(userKey)
+	if err != nil {
+		th.logger.Printf("failed to reset login attempts:%v", err)
+	}
+
+	err = th.cancelScheduledDeletion(user)
+	if err != nil {
+		th.logger.Printf("failed to cancel account deletion:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create token"})
+		return
+	}
+	accessToken, refreshToken, err := th.tokenStore.CreateTokenPair(user.ID, "", clientInfo(r))
+	if err != nil {
+		th.logger.Printf("failed to create new token:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create token"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": accessToken, "refresh_token": refreshToken})
+}
+
+// @Summary      Refresh an access token
+// @Description  Exchanges a refresh token for a new access token and a new refresh token.
+// @Description  Each refresh token can be used once; replaying an old one revokes every token from that login.
+// @Tags         Tokens
+// @Accept       json
+// @Produce      json
+//
+// @Param token body refreshTokenRequest true "Refresh token"
+//
+// @Success      201 {object} utils.Envelope{auth_token=tokens.Token,refresh_token=tokens.Token} "Returns the rotated tokens"
+// @Failure      400 {object} utils.Envelope "Invalid input or bad request"
+// @Failure      401 {object} utils.Envelope "Invalid, expired or reused refresh token"
+// @Failure      500 {object} utils.Envelope "Server error while refreshing the token"
+//
+// @Router       /tokens/refresh [post]
+func (th *TokenHandler) HandleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req refreshTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.RefreshToken == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	accessToken, refreshToken, err := th.tokenStore.RotateRefreshToken(req.RefreshToken, clientInfo(r))
+	if errors.Is(err, store.ErrTokenReused) {
+		th.logger.Printf("refresh token reuse detected, revoking token family")
+		hash := sha256.Sum256([]byte(req.RefreshToken))
+		err = th.tokenStore.DeleteTokenFamily(hash[:])
+		if err != nil {
+			th.logger.Printf("failed to revoke token family:%v", err)
+		}
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		th.logger.Printf("failed to rotate refresh token:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to refresh token"})
+		return
+	}
+	if refreshToken == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired refresh token"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": accessToken, "refresh_token": refreshToken})
+}
+
+// @Summary      Log out the current session
+// @Description  Revokes the bearer token used to authenticate this request along with its refresh token.
+// @Tags         Tokens
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Success      204 "Token revoked"
+// @Failure      401 {object} utils.Envelope "Missing or invalid token"
+// @Failure      500 {object} utils.Envelope "Server error while revoking the token"
+//
+// @Router       /tokens/current [delete]
+func (th *TokenHandler) HandleRevokeCurrentToken(w http.ResponseWriter, r *http.Request) {
+	hash := sha256.Sum256([]byte(middleware.GetToken(r)))
+	err := th.tokenStore.DeleteTokenFamily(hash[:])
+	if err != nil {
+		th.logger.Printf("failed to revoke token:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to revoke token"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Log out every session
+// @Description  Revokes all authentication tokens issued to the current user.
+// @Tags         Tokens
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Success      204 "Tokens revoked"
+// @Failure      401 {object} utils.Envelope "Missing or invalid token"
+// @Failure      500 {object} utils.Envelope "Server error while revoking tokens"
+//
+// @Router       /tokens [delete]
+func (th *TokenHandler) HandleRevokeAllTokens(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+	for _, scope := range []string{tokens.ScopeAuth, tokens.ScopeRefresh} {
+		err := th.tokenStore.DeleteAllTokensForUser(currentUser.ID, scope)
+		if err != nil {
+			th.logger.Printf("failed to revoke tokens for user:%v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to revoke tokens"})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Unlock a user account
+// @Description  Clears the failed login counter and any lockout for a user.
+// @Tags         Users
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param id path int true "User ID"
+//
+// @Success      204 "Account unlocked"
+// @Failure      400 {object} utils.Envelope "Invalid user ID"
+// @Failure      403 {object} utils.Envelope "Missing users:unlock permission"
+// @Failure      404 {object} utils.Envelope "User not found"
+// @Failure      500 {object} utils.Envelope "Server error while unlocking the account"
+//
+// @Router       /users/{id}/lockout [delete]
+func (th *TokenHandler) HandleUnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.ReadIDParams(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user ID"})
+		return
+	}
+	user, err := th.userStore.GetUserByID(userID)
+	if err != nil {
+		th.logger.Printf("failed to get user by ID:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch user"})
+		return
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "user not found"})
+		return
+	}
+	err = th.loginAttemptStore.Reset(userAttemptKey(user.Username))
+	if err != nil {
+		th.logger.Printf("failed to reset login attempts:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to unlock user"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      List active sessions
+// @Description  Lists the current user's logins with where and when they were created and last used.
+// @Tags         Sessions
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Success      200 {object} utils.Envelope{sessions=[]store.Session} "Returns the sessions"
+// @Failure      500 {object} utils.Envelope "Server error while fetching sessions"
+//
+// @Router       /sessions [get]
+func (th *TokenHandler) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	currentHash := sha256.Sum256([]byte(middleware.GetToken(r)))
+	sessions, err := th.tokenStore.GetSessionsForUser(middleware.GetUser(r).ID, currentHash[:])
+	if err != nil {
+		th.logger.Printf("failed to get sessions:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch sessions"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"sessions": sessions})
+}
+
+// @Summary      Revoke a session
+// @Description  Signs out one of the current user's logins.
+// @Tags         Sessions
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param id path int true "Session ID"
+//
+// @Success      204 "Session revoked"
+// @Failure      400 {object} utils.Envelope "Invalid session ID"
+// @Failure      404 {object} utils.Envelope "Session not found"
+// @Failure      500 {object} utils.Envelope "Server error while revoking the session"
+//
+// @Router       /sessions/{id} [delete]
+func (th *TokenHandler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := utils.ReadIDParams(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid session id"})
+		return
+	}
+	err = th.tokenStore.DeleteSession(sessionID, middleware.GetUser(r).ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "session not found"})
+		return
+	}
+	if err != nil {
+		th.logger.Printf("failed to revoke session:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to revoke session"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
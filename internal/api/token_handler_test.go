@@ -0,0 +1,545 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/passwords"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+// fakeTokenStore keeps tokens in memory. Tokens added by tests use their
+// label as the plaintext so that expectations can refer to them by name.
+type fakeTokenStore struct {
+	store.TokenStore
+	tokens []*tokens.Token
+	used   map[string]bool
+	err    error
+}
+
+func (f *fakeTokenStore) add(plaintext string, userID int, scope, family string) *tokens.Token {
+	hash := sha256.Sum256([]byte(plaintext))
+	token := &tokens.Token{Plaintext: plaintext, Hash: hash[:], UserID: userID, Expiry: time.Now().Add(time.Hour), Scope: scope, Family: family}
+	f.tokens = append(f.tokens, token)
+	return token
+}
+
+func (f *fakeTokenStore) remaining() []string {
+	plaintexts := []string{}
+	for _, token := range f.tokens {
+		plaintexts = append(plaintexts, token.Plaintext)
+	}
+	return plaintexts
+}
+
+// families returns the families that still hold tokens, in insertion order.
+func (f *fakeTokenStore) families() []string {
+	families := []string{}
+	for _, token := range f.tokens {
+		if !slices.Contains(families, token.Family) {
+			families = append(families, token.Family)
+		}
+	}
+	return families
+}
+
+func (f *fakeTokenStore) DeleteToken(hash []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.tokens = slices.DeleteFunc(f.tokens, func(token *tokens.Token) bool {
+		return bytes.Equal(token.Hash, hash)
+	})
+	return nil
+}
+
+func (f *fakeTokenStore) CreateTokenPair(userID int, family string, client tokens.Client) (*tokens.Token, *tokens.Token, error) {
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	if family == "" {
+		family = fmt.Sprintf("login-%d", len(f.tokens))
+	}
+	access, err := tokens.GenerateToken(userID, tokens.AccessTokenTTL, tokens.ScopeAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, err := tokens.GenerateToken(userID, tokens.RefreshTokenTTL, tokens.ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+	access.Family, access.Client = family, client
+	refresh.Family, refresh.Client = family, client
+	f.tokens = append(f.tokens, access, refresh)
+	return access, refresh, nil
+}
+
+func (f *fakeTokenStore) CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	token, err := tokens.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	f.tokens = append(f.tokens, token)
+	return token, nil
+}
+
+// lookup returns the unexpired token with the given plaintext and scope.
+func (f *fakeTokenStore) lookup(scope, tokenPlainText string) *tokens.Token {
+	hash := sha256.Sum256([]byte(tokenPlainText))
+	for _, token := range f.tokens {
+		if bytes.Equal(token.Hash, hash[:]) && token.Scope == scope && time.Now().Before(token.Expiry) {
+			return token
+		}
+	}
+	return nil
+}
+
+func (f *fakeTokenStore) RotateRefreshToken(tokenPlainText string, client tokens.Client) (*tokens.Token, *tokens.Token, error) {
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	hash := sha256.Sum256([]byte(tokenPlainText))
+	for _, old := range f.tokens {
+		if !bytes.Equal(old.Hash, hash[:]) || old.Scope != tokens.ScopeRefresh {
+			continue
+		}
+		if f.used[string(old.Hash)] {
+			return nil, nil, store.ErrTokenReused
+		}
+		if time.Now().After(old.Expiry) {
+			return nil, nil, nil
+		}
+		access, err := tokens.GenerateToken(old.UserID, tokens.AccessTokenTTL, tokens.ScopeAuth)
+		if err != nil {
+			return nil, nil, err
+		}
+		refresh, err := tokens.GenerateToken(old.UserID, tokens.RefreshTokenTTL, tokens.ScopeRefresh)
+		if err != nil {
+			return nil, nil, err
+		}
+		access.Family, access.Client = old.Family, client
+		refresh.Family, refresh.Client = old.Family, client
+		if f.used == nil {
+			f.used = map[string]bool{}
+		}
+		f.used[string(old.Hash)] = true
+		f.tokens = append(f.tokens, access, refresh)
+		return access, refresh, nil
+	}
+	return nil, nil, nil
+}
+
+// sessionID derives a stable id for a family.
+func sessionID(family string) int64 {
+	hash := sha256.Sum256([]byte(family))
+	return int64(binary.BigEndian.Uint32(hash[:]))
+}
+
+func (f *fakeTokenStore) GetSessionsForUser(userID int, currentHash []byte) ([]*store.Session, error) {
+	sessions := []*store.Session{}
+	for _, token := range f.tokens {
+		if token.UserID != userID || token.Scope != tokens.ScopeRefresh || f.used[string(token.Hash)] {
+			continue
+		}
+		current := false
+		for _, other := range f.tokens {
+			current = current || (other.Family == token.Family && bytes.Equal(other.Hash, currentHash))
+		}
+		sessions = append(sessions, &store.Session{ID: sessionID(token.Family), Current: current})
+	}
+	return sessions, nil
+}
+
+func (f *fakeTokenStore) DeleteSession(id int64, userID int) error {
+	n := len(f.tokens)
+	f.tokens = slices.DeleteFunc(f.tokens, func(token *tokens.Token) bool {
+		return token.UserID == userID && token.Family != "" && sessionID(token.Family) == id
+	})
+	if len(f.tokens) == n {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (f *fakeTokenStore) DeleteAllTokensForUser(userID int, scope string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.tokens = slices.DeleteFunc(f.tokens, func(token *tokens.Token) bool {
+		return token.UserID == userID && token.Scope == scope
+	})
+	return nil
+}
+
+func (f *fakeTokenStore) DeleteTokenFamily(hash []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	family := ""
+	for _, token := range f.tokens {
+		if bytes.Equal(token.Hash, hash) {
+			family = token.Family
+		}
+	}
+	f.tokens = slices.DeleteFunc(f.tokens, func(token *tokens.Token) bool {
+		return bytes.Equal(token.Hash, hash) || (family != "" && token.Family == family)
+	})
+	return nil
+}
+
+type fakeLoginAttemptStore struct {
+	store.LoginAttemptStore
+	failures    map[string]int
+	lockedUntil map[string]time.Time
+}
+
+func newFakeLoginAttemptStore() *fakeLoginAttemptStore {
+	return &fakeLoginAttemptStore{failures: map[string]int{}, lockedUntil: map[string]time.Time{}}
+}
+
+func (f *fakeLoginAttemptStore) GetLockedUntil(key string) (time.Time, error) {
+	return f.lockedUntil[key], nil
+}
+
+func (f *fakeLoginAttemptStore) RecordFailure(key string, window time.Duration) (int, error) {
+	f.failures[key]++
+	return f.failures[key], nil
+}
+
+func (f *fakeLoginAttemptStore) Lock(key string, until time.Time) error {
+	f.lockedUntil[key] = until
+	return nil
+}
+
+func (f *fakeLoginAttemptStore) Reset(key string) error {
+	delete(f.failures, key)
+	delete(f.lockedUntil, key)
+	return nil
+}
+
+// testPasswordHash hashes with cheap parameters to keep handler tests fast.
+func testPasswordHash(t *testing.T, plaintext string) []byte {
+	t.Helper()
+	hash, err := passwords.HashWithParams(plaintext, passwords.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	require.NoError(t, err)
+	return hash
+}
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		expected time.Duration
+	}{
+		{name: "at threshold", failures: 5, expected: time.Minute},
+		{name: "one past threshold", failures: 6, expected: 2 * time.Minute},
+		{name: "several past threshold", failures: 9, expected: 16 * time.Minute},
+		{name: "capped", failures: 12, expected: time.Hour},
+		{name: "far past threshold", failures: 200, expected: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, lockoutDuration(tt.failures, maxUserFailures))
+		})
+	}
+}
+
+func TestRevokeTokens(t *testing.T) {
+	tests := []struct {
+		name              string
+		all               bool
+		bearer            string
+		storeErr          error
+		expectedStatus    int
+		expectedRemaining []string
+	}{
+		{
+			name:              "current login",
+			bearer:            "first-access",
+			expectedStatus:    http.StatusNoContent,
+			expectedRemaining: []string{"second-access", "second-refresh", "reset", "bob-access"},
+		},
+		{
+			name:              "current login by refresh token",
+			bearer:            "second-refresh",
+			expectedStatus:    http.StatusNoContent,
+			expectedRemaining: []string{"first-access", "first-refresh", "reset", "bob-access"},
+		},
+		{
+			name:              "unknown token",
+			bearer:            "unknown",
+			expectedStatus:    http.StatusNoContent,
+			expectedRemaining: []string{"first-access", "first-refresh", "second-access", "second-refresh", "reset", "bob-access"},
+		},
+		{
+			name:              "every login",
+			all:               true,
+			bearer:            "first-access",
+			expectedStatus:    http.StatusNoContent,
+			expectedRemaining: []string{"reset", "bob-access"},
+		},
+		{
+			name:              "current login store error",
+			bearer:            "first-access",
+			storeErr:          errors.New("connection reset"),
+			expectedStatus:    http.StatusInternalServerError,
+			expectedRemaining: []string{"first-access", "first-refresh", "second-access", "second-refresh", "reset", "bob-access"},
+		},
+		{
+			name:              "every login store error",
+			all:               true,
+			bearer:            "first-access",
+			storeErr:          errors.New("connection reset"),
+			expectedStatus:    http.StatusInternalServerError,
+			expectedRemaining: []string{"first-access", "first-refresh", "second-access", "second-refresh", "reset", "bob-access"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenStore := &fakeTokenStore{err: tt.storeErr}
+			tokenStore.add("first-access", 1, tokens.ScopeAuth, "first")
+			tokenStore.add("first-refresh", 1, tokens.ScopeRefresh, "first")
+			tokenStore.add("second-access", 1, tokens.ScopeAuth, "second")
+			tokenStore.add("second-refresh", 1, tokens.ScopeRefresh, "second")
+			tokenStore.add("reset", 1, tokens.ScopePasswordReset, "")
+			tokenStore.add("bob-access", 2, tokens.ScopeAuth, "bob")
+			th := &TokenHandler{tokenStore: tokenStore, logger: log.New(io.Discard, "", 0)}
+
+			req := httptest.NewRequest(http.MethodDelete, "/tokens/current", nil)
+			req = middleware.SetUser(req, &store.User{ID: 1, Username: "alice"})
+			req = req.WithContext(context.WithValue(req.Context(), middleware.TokenContextKey, tt.bearer))
+			rr := httptest.NewRecorder()
+			if tt.all {
+				th.HandleRevokeAllTokens(rr, req)
+			} else {
+				th.HandleRevokeCurrentToken(rr, req)
+			}
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedRemaining, tokenStore.remaining())
+		})
+	}
+}
+
+func TestHandleRefreshToken(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		expectedStatus   int
+		expectedFamilies []string
+	}{
+		{name: "rotates", body: `{"refresh_token":"first-refresh"}`, expectedStatus: http.StatusCreated, expectedFamilies: []string{"first", "second", "bob"}},
+		{name: "reuse revokes the family", body: `{"refresh_token":"used-refresh"}`, expectedStatus: http.StatusUnauthorized, expectedFamilies: []string{"second", "bob"}},
+		{name: "expired", body: `{"refresh_token":"expired-refresh"}`, expectedStatus: http.StatusUnauthorized, expectedFamilies: []string{"first", "second", "bob"}},
+		{name: "unknown", body: `{"refresh_token":"unknown"}`, expectedStatus: http.StatusUnauthorized, expectedFamilies: []string{"first", "second", "bob"}},
+		{name: "access token", body: `{"refresh_token":"first-access"}`, expectedStatus: http.StatusUnauthorized, expectedFamilies: []string{"first", "second", "bob"}},
+		{name: "missing token", body: `{}`, expectedStatus: http.StatusBadRequest, expectedFamilies: []string{"first", "second", "bob"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenStore := &fakeTokenStore{}
+			tokenStore.add("first-access", 1, tokens.ScopeAuth, "first")
+			tokenStore.add("first-refresh", 1, tokens.ScopeRefresh, "first")
+			used := tokenStore.add("used-refresh", 1, tokens.ScopeRefresh, "first")
+			tokenStore.used = map[string]bool{string(used.Hash): true}
+			tokenStore.add("second-refresh", 1, tokens.ScopeRefresh, "second")
+			tokenStore.add("expired-refresh", 1, tokens.ScopeRefresh, "second").Expiry = time.Now().Add(-time.Minute)
+			tokenStore.add("bob-refresh", 2, tokens.ScopeRefresh, "bob")
+			th := &TokenHandler{tokenStore: tokenStore, logger: log.New(io.Discard, "", 0)}
+
+			req := httptest.NewRequest(http.MethodPost, "/tokens/refresh", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			th.HandleRefreshToken(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedFamilies, tokenStore.families())
+		})
+	}
+}
+
+func TestRefreshTokenReuseRevokesRotatedTokens(t *testing.T) {
+	tokenStore := &fakeTokenStore{}
+	tokenStore.add("first-refresh", 1, tokens.ScopeRefresh, "first")
+	tokenStore.add("second-refresh", 1, tokens.ScopeRefresh, "second")
+	th := &TokenHandler{tokenStore: tokenStore, logger: log.New(io.Discard, "", 0)}
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/tokens/refresh", strings.NewReader(`{"refresh_token":"`+token+`"}`))
+		rr := httptest.NewRecorder()
+		th.HandleRefreshToken(rr, req)
+		return rr
+	}
+
+	rr := refresh("first-refresh")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var rotated struct {
+		RefreshToken tokens.Token `json:"refresh_token"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&rotated))
+	require.NotEmpty(t, rotated.RefreshToken.Plaintext)
+
+	assert.Equal(t, http.StatusUnauthorized, refresh("first-refresh").Code)
+	assert.Equal(t, http.StatusUnauthorized, refresh(rotated.RefreshToken.Plaintext).Code)
+	assert.Equal(t, []string{"second"}, tokenStore.families())
+	assert.Equal(t, http.StatusCreated, refresh("second-refresh").Code)
+}
+
+func TestCreateTokenLockout(t *testing.T) {
+	const wrong, right = "Wrong-Pass1", "Current-Pass1"
+
+	tests := []struct {
+		name             string
+		username         string
+		passwords        []string
+		expectedStatuses []int
+		expectedFailures int
+	}{
+		{
+			name:             "failures below the threshold",
+			username:         "alice",
+			passwords:        []string{wrong, wrong, wrong, wrong},
+			expectedStatuses: []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized},
+			expectedFailures: 4,
+		},
+		{
+			name:             "threshold locks the account",
+			username:         "alice",
+			passwords:        []string{wrong, wrong, wrong, wrong, wrong, right},
+			expectedStatuses: []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests},
+			expectedFailures: 5,
+		},
+		{
+			name:             "success resets the counter",
+			username:         "alice",
+			passwords:        []string{wrong, wrong, wrong, wrong, right, wrong, wrong, wrong, wrong, right},
+			expectedStatuses: []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusCreated, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusCreated},
+			expectedFailures: 0,
+		},
+		{
+			name:             "username case does not split the counter",
+			username:         "ALICE",
+			passwords:        []string{wrong, wrong, wrong, wrong, wrong},
+			expectedStatuses: []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized},
+			expectedFailures: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice := &store.User{ID: 1, Username: "alice", Activated: true}
+			alice.PasswordHash.Hash = testPasswordHash(t, right)
+			attempts := newFakeLoginAttemptStore()
+			th := &TokenHandler{
+				tokenStore:        &fakeTokenStore{},
+				userStore:         &fakeUserStore{users: map[int]*store.User{1: alice}},
+				loginAttemptStore: attempts,
+				totpStore:         &fakeTOTPStore{},
+				logger:            log.New(io.Discard, "", 0),
+			}
+
+			var statuses []int
+			for _, password := range tt.passwords {
+				body := fmt.Sprintf(`{"username":%q,"password":%q}`, tt.username, password)
+				req := httptest.NewRequest(http.MethodPost, "/tokens", strings.NewReader(body))
+				rr := httptest.NewRecorder()
+				th.CreateToken(rr, req)
+				statuses = append(statuses, rr.Code)
+				if rr.Code == http.StatusTooManyRequests {
+					assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+				}
+			}
+
+			assert.Equal(t, tt.expectedStatuses, statuses)
+			assert.Equal(t, tt.expectedFailures, attempts.failures["user:alice"])
+		})
+	}
+}
+
+func TestClientInfoUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		expected  string
+	}{
+		{name: "short", userAgent: "curl/8.0", expected: "curl/8.0"},
+		{name: "at the limit", userAgent: strings.Repeat("a", 255), expected: strings.Repeat("a", 255)},
+		{name: "ascii over the limit", userAgent: strings.Repeat("a", 300), expected: strings.Repeat("a", 255)},
+		{name: "multi-byte rune across the limit", userAgent: strings.Repeat("a", 254) + "é", expected: strings.Repeat("a", 254)},
+		{name: "multi-byte runes only", userAgent: strings.Repeat("日", 100), expected: strings.Repeat("日", 85)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/tokens", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+
+			client := clientInfo(req)
+
+			assert.Equal(t, tt.expected, client.UserAgent)
+			assert.True(t, utf8.ValidString(client.UserAgent))
+		})
+	}
+}
+
+func TestCreateTokenCancelsScheduledDeletion(t *testing.T) {
+	tests := []struct {
+		name              string
+		mfaEnabled        bool
+		expectedStatus    int
+		expectedScheduled bool
+	}{
+		{name: "login cancels the deletion", expectedStatus: http.StatusCreated},
+		{name: "pending second factor keeps the deletion", mfaEnabled: true, expectedStatus: http.StatusOK, expectedScheduled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheduled := time.Now().Add(24 * time.Hour)
+			alice := &store.User{ID: 1, Username: "alice", Activated: true, DeletionScheduledFor: &scheduled}
+			alice.PasswordHash.Hash = testPasswordHash(t, "Current-Pass1")
+			totpStore := &fakeTOTPStore{totps: map[int]*store.TOTP{}}
+			if tt.mfaEnabled {
+				totpStore = newEnabledTOTP(t)
+			}
+			th := &TokenHandler{
+				tokenStore:        &fakeTokenStore{},
+				userStore:         &fakeUserStore{users: map[int]*store.User{1: alice}},
+				loginAttemptStore: newFakeLoginAttemptStore(),
+				totpStore:         totpStore,
+				logger:            log.New(io.Discard, "", 0),
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/tokens", strings.NewReader(`{"username":"alice","password":"Current-Pass1"}`))
+			rr := httptest.NewRecorder()
+			th.CreateToken(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedScheduled, alice.DeletionScheduledFor != nil)
+		})
+	}
+}
@@ -1,21 +1,40 @@
 package api
 
 import (
+	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/alireza-akbarzadeh/fem_project/internal/mailer"
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
 	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
 	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
 	"github.com/alireza-akbarzadeh/fem_project/internal/validation"
 )
 
 type UserHandler struct {
-	UserStore store.UserStore
-	logger    *log.Logger
+	UserStore         store.UserStore
+	tokenStore        store.TokenStore
+	permissionStore   store.PermissionStore
+	loginAttemptStore store.LoginAttemptStore
+	mailer            mailer.Mailer
+	logger            *log.Logger
+	PasswordPolicy    *validation.PasswordPolicy
+	// DeletionGracePeriod is how long a deleted account can still be
+	// recovered by logging in.
+	DeletionGracePeriod time.Duration
 }
 
+const defaultDeletionGracePeriod = 30 * 24 * time.Hour
+
 type registerUserRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
@@ -23,31 +42,158 @@ type registerUserRequest struct {
 	Bio      string `json:"bio"`
 }
 
-func NewUserHandler(userStore store.UserStore, logger *log.Logger) *UserHandler {
-	return &UserHandler{
-		UserStore: userStore,
-		logger:    logger,
+type activateUserRequest struct {
+	Token string `json:"token"`
+}
+
+// updateMeRequest lists the profile fields a user may edit on their own
+// account. Fields left out of the request are not changed.
+type updateMeRequest struct {
+	Username     *string              `json:"username"`
+	Email        *string              `json:"email"`
+	Bio          *string              `json:"bio"`
+	DisplayName  *string              `json:"display_name"`
+	DateOfBirth  optional[store.Date] `json:"date_of_birth" swaggertype:"string" example:"1990-04-23"`
+	WeightUnit   *string              `json:"weight_unit" enums:"kg,lb"`
+	DistanceUnit *string              `json:"distance_unit" enums:"km,mi"`
+	Timezone     *string              `json:"timezone" example:"Europe/Berlin"`
+	HeightCM     optional[float64]    `json:"height_cm" swaggertype:"number"`
+}
+
+// optional tells a field missing from a JSON body apart from one that is
+// explicitly null, so nullable fields can be cleared.
+type optional[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (o *optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
 	}
+	o.Value = new(T)
+	return json.Unmarshal(data, o.Value)
 }
 
-func (h *UserHandler) RegisterUser(req *registerUserRequest) error {
-	if req.Username == "" || req.Email == "" || req.Password == "" {
-		return errors.New("username, email, and password are required")
+const (
+	maxDisplayNameLength = 100
+	minHeightCM          = 30
+	maxHeightCM          = 300
+)
+
+// applyProfile copies the profile preferences from req onto user after
+// validating them.
+func applyProfile(user *store.User, req *updateMeRequest) error {
+	if req.DisplayName != nil {
+		user.DisplayName = validation.NormalizeDisplayName(*req.DisplayName)
+		if utf8.RuneCountInString(user.DisplayName) > maxDisplayNameLength {
+			return fmt.Errorf("display_name must be at most %d characters long", maxDisplayNameLength)
+		}
+	}
+	if req.DateOfBirth.Set {
+		dob := req.DateOfBirth.Value
+		if dob != nil && (dob.Year() < 1900 || dob.After(time.Now())) {
+			return errors.New("date_of_birth must be between 1900-01-01 and today")
+		}
+		user.DateOfBirth = dob
+	}
+	if req.WeightUnit != nil {
+		if *req.WeightUnit != store.WeightUnitKG && *req.WeightUnit != store.WeightUnitLB {
+			return errors.New("weight_unit must be kg or lb")
+		}
+		user.WeightUnit = *req.WeightUnit
 	}
+	if req.DistanceUnit != nil {
+		if *req.DistanceUnit != store.DistanceUnitKM && *req.DistanceUnit != store.DistanceUnitMI {
+			return errors.New("distance_unit must be km or mi")
+		}
+		user.DistanceUnit = *req.DistanceUnit
+	}
+	if req.Timezone != nil {
+		if !validation.IsTimezoneValid(*req.Timezone) {
+			return errors.New("timezone must be an IANA time zone such as Europe/Berlin")
+		}
+		user.Timezone = *req.Timezone
+	}
+	if req.HeightCM.Set {
+		height := req.HeightCM.Value
+		if height != nil && (*height < minHeightCM || *height > maxHeightCM) {
+			return fmt.Errorf("height_cm must be between %d and %d", minHeightCM, maxHeightCM)
+		}
+		user.HeightCM = height
+	}
+	return nil
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
 
-	if len(req.Username) > 50 {
-		return errors.New("username exceeds maximum length of 50 characters")
+func NewUserHandler(userStore store.UserStore, tokenStore store.TokenStore, permissionStore store.PermissionStore, loginAttemptStore store.LoginAttemptStore, mailer mailer.Mailer, logger *log.Logger) *UserHandler {
+	return &UserHandler{
+		UserStore:           userStore,
+		tokenStore:          tokenStore,
+		permissionStore:     permissionStore,
+		loginAttemptStore:   loginAttemptStore,
+		mailer:              mailer,
+		logger:              logger,
+		PasswordPolicy:      validation.DefaultPasswordPolicy(),
+		DeletionGracePeriod: defaultDeletionGracePeriod,
 	}
+}
+
+// normalizeProfile applies the same Unicode normalization to the username
+// and email that is used for the uniqueness checks in the database.
+func normalizeProfile(user *store.User) {
+	user.Username = validation.NormalizeUsername(user.Username)
+	user.Email = validation.NormalizeEmail(user.Email)
+}
 
-	if len(req.Email) > 100 {
+func validateEmail(email string) error {
+	if len(email) > 100 {
 		return errors.New("email exceeds maximum length of 100 characters")
 	}
-	if !validation.IsEmailValid(req.Email) {
+	if !validation.IsEmailValid(email) {
 		return errors.New("invalid email format")
 	}
+	return nil
+}
 
-	if !validation.IsPasswordValid(req.Password) {
-		return errors.New("password must be at least 8 characters long and include uppercase, lowercase, number, and special character")
+// writeDuplicateUser responds with 409 Conflict when err reports a taken
+// username or email and returns whether it did.
+func writeDuplicateUser(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, store.ErrDuplicateUsername):
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "username is already taken"})
+	case errors.Is(err, store.ErrDuplicateEmail):
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "email is already registered"})
+	default:
+		return false
+	}
+	return true
+}
+
+func (h *UserHandler) RegisterUser(req *registerUserRequest) error {
+	req.Username = validation.NormalizeUsername(req.Username)
+	req.Email = validation.NormalizeEmail(req.Email)
+	if req.Username == "" || req.Email == "" || req.Password == "" {
+		return errors.New("username, email, and password are required")
+	}
+
+	err := validation.ValidateUsername(req.Username)
+	if err != nil {
+		return err
+	}
+	err = validateEmail(req.Email)
+	if err != nil {
+		return err
+	}
+
+	if failures := h.PasswordPolicy.Validate(req.Password); len(failures) > 0 {
+		return errors.New("password " + strings.Join(failures, ", "))
 	}
 	return nil
 }
@@ -56,6 +202,7 @@ func (h *UserHandler) RegisterUser(req *registerUserRequest) error {
 // @Description  Creates a new user in the system after validating the input.
 // @Description  The user must provide a unique username, a valid email address, and a secure password.
 // @Description  Optionally, a bio can be included for the user's profile.
+// @Description  An activation token is emailed to the new address.
 // @Tags         Users
 // @Accept       json
 // @Produce      json
@@ -64,6 +211,7 @@ func (h *UserHandler) RegisterUser(req *registerUserRequest) error {
 //
 // @Success      201 {object} utils.Envelope{user=store.User} "Returns created user information"
 // @Failure      400 {object} utils.Envelope "Invalid input or bad request"
+// @Failure      409 {object} utils.Envelope "Username or email is already taken"
 // @Failure      500 {object} utils.Envelope "Server error while creating the user"
 //
 // @Router       /users [post]
@@ -98,19 +246,370 @@ func (uh *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	createdUser, err := uh.UserStore.CreateUser(newUser)
+	token, err := uh.UserStore.CreateUserWithActivation(newUser, store.RoleUser)
+	if writeDuplicateUser(w, err) {
+		return
+	}
 	if err != nil {
 		uh.logger.Printf("failed to create user: %v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create user"})
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"user": createdUser})
+	go func() {
+		data := map[string]any{
+			"Username": newUser.Username,
+			"Token":    token.Plaintext,
+			"Expiry":   token.Expiry.Format(time.RFC1123),
+		}
+		err := uh.mailer.Send(newUser.Email, "user_welcome.tmpl", data)
+		if err != nil {
+			uh.logger.Printf("failed to send welcome email: %v", err)
+		}
+	}()
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"user": newUser})
 
 }
 
+// @Summary      Activate a user account
+// @Description  Confirms the email address of a new account using the token sent on registration.
+// @Tags         Users
+// @Accept       json
+// @Produce      json
+//
+// @Param token body activateUserRequest true "Activation token"
+//
+// @Success      200 {object} utils.Envelope{user=store.User} "Returns the activated user"
+// @Failure      400 {object} utils.Envelope "Invalid or expired activation token"
+// @Failure      500 {object} utils.Envelope "Server error while activating the user"
+//
+// @Router       /users/activated [put]
+func (uh *UserHandler) HandleActivateUser(w http.ResponseWriter, r *http.Request) {
+	var req activateUserRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		uh.logger.Printf("failed to decode activate user request: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	if req.Token == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "token is required"})
+		return
+	}
+
+	user, err := uh.UserStore.GetUserToken(tokens.ScopeActivation, req.Token)
+	if err != nil {
+		uh.logger.Printf("failed to get user by activation token: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid or expired activation token"})
+		return
+	}
+
+	err = uh.UserStore.ActivateUser(user.ID)
+	if err != nil {
+		uh.logger.Printf("failed to activate user: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to activate user"})
+		return
+	}
+	user.Activated = true
+
+	err = uh.tokenStore.DeleteAllTokensForUser(user.ID, tokens.ScopeActivation)
+	if err != nil {
+		uh.logger.Printf("failed to delete activation tokens: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+// @Summary      Get the current user
+// @Description  Returns the profile of the logged-in user.
+// @Tags         Users
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Success      200 {object} utils.Envelope{user=store.User} "Returns the current user"
+// @Failure      401 {object} utils.Envelope "Not logged in"
+//
+// @Router       /users/me [get]
+func (uh *UserHandler) HandleGetMe(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": middleware.GetUser(r)})
+}
+
+// @Summary      Update the current user
+// @Description  Updates the profile of the logged-in user. Only the fields present in the body
+// @Description  are changed; date_of_birth and height_cm can be cleared with null. Height is
+// @Description  always given in centimetres. Any other field is rejected.
+// @Tags         Users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param user body updateMeRequest true "Profile fields to change"
+//
+// @Success      200 {object} utils.Envelope{user=store.User} "Returns the updated user"
+// @Failure      400 {object} utils.Envelope "Invalid input or bad request"
+// @Failure      409 {object} utils.Envelope "Username or email is already taken"
+// @Failure      500 {object} utils.Envelope "Server error while updating the user"
+//
+// @Router       /users/me [patch]
+func (uh *UserHandler) HandleUpdateMe(w http.ResponseWriter, r *http.Request) {
+	var req updateMeRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&req)
+	if err != nil {
+		uh.logger.Printf("failed to decode update me request: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload: " + err.Error()})
+		return
+	}
+
+	// work on a copy so a failed update does not leak into the request context
+	user := *middleware.GetUser(r)
+	if req.Username != nil {
+		user.Username = validation.NormalizeUsername(*req.Username)
+		err = validation.ValidateUsername(user.Username)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+			return
+		}
+	}
+	if req.Email != nil {
+		user.Email = validation.NormalizeEmail(*req.Email)
+		err = validateEmail(user.Email)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+			return
+		}
+	}
+	if req.Bio != nil {
+		user.Bio = *req.Bio
+	}
+	err = applyProfile(&user, &req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	err = uh.UserStore.UpdateUser(&user)
+	if writeDuplicateUser(w, err) {
+		return
+	}
+	if err != nil {
+		uh.logger.Printf("failed to update user: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update user"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": &user})
+}
+
+// @Summary      Delete the current user
+// @Description  Schedules the logged-in user's account, workouts and sessions for deletion once the
+// @Description  grace period ends. Every session is signed out; logging in again cancels the deletion.
+// @Tags         Users
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Success      202 {object} utils.Envelope "Deletion scheduled, returns deletion_scheduled_for"
+// @Failure      500 {object} utils.Envelope "Server error while scheduling the deletion"
+//
+// @Router       /users/me [delete]
+func (uh *UserHandler) HandleDeleteMe(w http.ResponseWriter, r *http.Request) {
+	uh.scheduleDeletion(w, middleware.GetUser(r).ID)
+}
+
+// scheduleDeletion marks the account for removal after DeletionGracePeriod
+// and signs out all of its sessions, so the next login cancels it.
+func (uh *UserHandler) scheduleDeletion(w http.ResponseWriter, userID int) {
+	deleteAt := time.Now().Add(uh.DeletionGracePeriod)
+	err := uh.UserStore.ScheduleDeletion(userID, deleteAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		uh.logger.Printf("failed to schedule user deletion: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to delete user"})
+		return
+	}
+
+	for _, scope := range []string{tokens.ScopeAuth, tokens.ScopeRefresh} {
+		err = uh.tokenStore.DeleteAllTokensForUser(userID, scope)
+		if err != nil {
+			uh.logger.Printf("failed to revoke %s tokens: %v", scope, err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{
+		"message":                "account scheduled for deletion, log in before then to cancel",
+		"deletion_scheduled_for": deleteAt,
+	})
+}
+
+// @Summary      Change the current user's password
+// @Description  Replaces the password of the logged-in user after checking the current one.
+// @Description  The new password must satisfy the password policy and may not reuse a recent password.
+// @Description  Every other session for the account is signed out.
+// @Tags         Users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param request body changePasswordRequest true "Current and new password"
+//
+// @Success      200 {object} utils.Envelope "Password changed"
+// @Failure      400 {object} utils.Envelope "Invalid input, incorrect current password, or the new password breaks the password policy"
+// @Failure      429 {object} utils.Envelope "Too many failed attempts, see Retry-After"
+// @Failure      500 {object} utils.Envelope "Server error while changing the password"
+//
+// @Router       /users/me/password [put]
+func (uh *UserHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		uh.logger.Printf("failed to decode change password request: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "current_password and new_password are required"})
+		return
+	}
+
+	// wrong current passwords count towards the same lockout as failed logins
+	user := middleware.GetUser(r)
+	userKey := userAttemptKey(user.Username)
+	until, err := lockedUntil(uh.loginAttemptStore, userKey)
+	if err != nil {
+		uh.logger.Printf("failed to check login lockout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if time.Now().Before(until) {
+		writeLockedOut(w, until)
+		return
+	}
+	matches, err := user.PasswordHash.Matches(req.CurrentPassword)
+	if err != nil {
+		uh.logger.Printf("failed to check current password: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if !matches {
+		err = recordFailure(uh.loginAttemptStore, userKey, maxUserFailures)
+		if err != nil {
+			uh.logger.Printf("failed to record login failure: %v", err)
+		}
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "current password is incorrect"})
+		return
+	}
+	err = uh.loginAttemptStore.Reset(userKey)
+	if err != nil {
+		uh.logger.Printf("failed to reset login attempts: %v", err)
+	}
+
+	failures, err := checkNewPassword(uh.UserStore, uh.PasswordPolicy, user, req.NewPassword)
+	if err != nil {
+		uh.logger.Printf("failed to check password history: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if len(failures) > 0 {
+		writePasswordPolicyFailures(w, failures)
+		return
+	}
+
+	err = user.PasswordHash.Set(req.NewPassword)
+	if err != nil {
+		uh.logger.Printf("failed to hash password: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to process password"})
+		return
+	}
+	err = uh.UserStore.ChangePassword(user.ID, user.PasswordHash.Hash, uh.PasswordPolicy.HistorySize)
+	if err != nil {
+		uh.logger.Printf("failed to change password: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to change password"})
+		return
+	}
+
+	// keep the session that made the change and sign out every other one
+	err = uh.tokenStore.DeleteAllTokensForUser(user.ID, tokens.ScopePasswordReset)
+	if err != nil {
+		uh.logger.Printf("failed to revoke password reset tokens: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	currentHash := sha256.Sum256([]byte(middleware.GetToken(r)))
+	sessions, err := uh.tokenStore.GetSessionsForUser(user.ID, currentHash[:])
+	if err != nil {
+		uh.logger.Printf("failed to get sessions: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	for _, session := range sessions {
+		if session.Current {
+			continue
+		}
+		err = uh.tokenStore.DeleteSession(session.ID, user.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			uh.logger.Printf("failed to revoke session: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "password changed successfully"})
+}
+
+// @Summary      Check username availability
+// @Description  Reports whether a username can still be registered. The username is normalized
+// @Description  the same way as on registration and compared case-insensitively.
+// @Tags         Users
+// @Produce      json
+//
+// @Param username query string true "Username to check"
+//
+// @Success      200 {object} utils.Envelope "Returns username, available and, when unavailable, reason"
+// @Failure      400 {object} utils.Envelope "Missing username"
+// @Failure      500 {object} utils.Envelope "Server error while checking the username"
+//
+// @Router       /users/availability [get]
+func (uh *UserHandler) HandleUsernameAvailability(w http.ResponseWriter, r *http.Request) {
+	username := validation.NormalizeUsername(r.URL.Query().Get("username"))
+	if username == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "username is required"})
+		return
+	}
+
+	err := validation.ValidateUsername(username)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"username": username, "available": false, "reason": err.Error()})
+		return
+	}
+	taken, err := uh.UserStore.IsUsernameTaken(username)
+	if err != nil {
+		uh.logger.Printf("failed to check username availability: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if taken {
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"username": username, "available": false, "reason": "username is already taken"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"username": username, "available": true})
+}
+
 func (uh *UserHandler) HandleGetUserByUsername(w http.ResponseWriter, r *http.Request) {
-	username := r.URL.Query().Get("username")
+	username := validation.NormalizeUsername(r.URL.Query().Get("username"))
 	if username == "" {
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "username is required"})
 		return
@@ -140,7 +639,50 @@ func (uh *UserHandler) HandleUpdateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = uh.UserStore.UpdateUser(&req)
+	currentUser := middleware.GetUser(r)
+	if req.ID == 0 {
+		req.ID = currentUser.ID
+	}
+	if req.ID != currentUser.ID {
+		permissions, err := uh.permissionStore.GetAllForUser(currentUser.ID)
+		if err != nil {
+			uh.logger.Printf("failed to get permissions: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		if !permissions.Include("users:update") {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "you can only update your own account"})
+			return
+		}
+	}
+
+	// only the account fields are replaced; profile preferences are edited through PATCH /users/me
+	user, err := uh.UserStore.GetUserByID(int64(req.ID))
+	if err != nil {
+		uh.logger.Printf("failed to get user by ID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update user"})
+		return
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "user not found"})
+		return
+	}
+	user.Username, user.Email, user.Bio = req.Username, req.Email, req.Bio
+
+	normalizeProfile(user)
+	err = validation.ValidateUsername(user.Username)
+	if err == nil {
+		err = validateEmail(user.Email)
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	err = uh.UserStore.UpdateUser(user)
+	if writeDuplicateUser(w, err) {
+		return
+	}
 	if err != nil {
 		uh.logger.Printf("failed to update user: %v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update user"})
@@ -151,13 +693,7 @@ func (uh *UserHandler) HandleUpdateUser(w http.ResponseWriter, r *http.Request)
 }
 
 func (uh *UserHandler) HandleGetUserByID(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "user ID is required"})
-		return
-	}
-
-	userID, err := utils.ParseInt64(id)
+	userID, err := utils.ReadIDParams(r)
 	if err != nil {
 		uh.logger.Printf("invalid user ID: %v", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user ID"})
@@ -179,24 +715,12 @@ func (uh *UserHandler) HandleGetUserByID(w http.ResponseWriter, r *http.Request)
 }
 
 func (uh *UserHandler) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "user ID is required"})
-		return
-	}
-	userID, err := utils.ParseInt64(id)
+	userID, err := utils.ReadIDParams(r)
 	if err != nil {
 		uh.logger.Printf("invalid user ID: %v", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user ID"})
 		return
 	}
 
-	err = uh.UserStore.DeleteUser(userID)
-	if err != nil {
-		uh.logger.Printf("failed to delete user: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to delete user"})
-		return
-	}
-
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "user deleted successfully"})
+	uh.scheduleDeletion(w, int(userID))
 }
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/passwords"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestHandleUpdateMe(t *testing.T) {
+	dob, err := store.ParseDate("1990-04-23")
+	require.NoError(t, err)
+	height := 170.5
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedUser   *store.User
+	}{
+		{
+			name:           "partial update keeps other fields",
+			body:           `{"bio":"new bio"}`,
+			expectedStatus: http.StatusOK,
+			expectedUser: &store.User{
+				ID: 1, Username: "alice", Email: "alice@example.com", Bio: "new bio",
+				DateOfBirth: &dob, WeightUnit: "kg", DistanceUnit: "km", Timezone: "UTC", HeightCM: &height,
+			},
+		},
+		{
+			name:           "username and email",
+			body:           `{"username":"alice2","email":"alice2@example.com"}`,
+			expectedStatus: http.StatusOK,
+			expectedUser: &store.User{
+				ID: 1, Username: "alice2", Email: "alice2@example.com", Bio: "old bio",
+				DateOfBirth: &dob, WeightUnit: "kg", DistanceUnit: "km", Timezone: "UTC", HeightCM: &height,
+			},
+		},
+		{name: "id is not editable", body: `{"id":2,"bio":"x"}`, expectedStatus: http.StatusBadRequest},
+		{name: "activated is not editable", body: `{"activated":true}`, expectedStatus: http.StatusBadRequest},
+		{name: "invalid email", body: `{"email":"nope"}`, expectedStatus: http.StatusBadRequest},
+		{name: "empty username", body: `{"username":""}`, expectedStatus: http.StatusBadRequest},
+		{name: "reserved username", body: `{"username":"Admin"}`, expectedStatus: http.StatusBadRequest},
+		{name: "taken username", body: `{"username":"BOB"}`, expectedStatus: http.StatusConflict},
+		{
+			name:           "profile preferences",
+			body:           `{"display_name":" Alice A. ","weight_unit":"lb","distance_unit":"mi","timezone":"Europe/Berlin","height_cm":170.5}`,
+			expectedStatus: http.StatusOK,
+			expectedUser: &store.User{
+				ID: 1, Username: "alice", Email: "alice@example.com", Bio: "old bio", DisplayName: "Alice A.",
+				DateOfBirth: &dob, WeightUnit: "lb", DistanceUnit: "mi", Timezone: "Europe/Berlin", HeightCM: &height,
+			},
+		},
+		{
+			name:           "null clears nullable fields",
+			body:           `{"date_of_birth":null,"height_cm":null}`,
+			expectedStatus: http.StatusOK,
+			expectedUser:   &store.User{ID: 1, Username: "alice", Email: "alice@example.com", Bio: "old bio", WeightUnit: "kg", DistanceUnit: "km", Timezone: "UTC"},
+		},
+		{name: "unknown unit", body: `{"weight_unit":"stone"}`, expectedStatus: http.StatusBadRequest},
+		{name: "unknown timezone", body: `{"timezone":"Mars/Base"}`, expectedStatus: http.StatusBadRequest},
+		{name: "future birthday", body: `{"date_of_birth":"2999-01-01"}`, expectedStatus: http.StatusBadRequest},
+		{name: "malformed birthday", body: `{"date_of_birth":"01/02/1990"}`, expectedStatus: http.StatusBadRequest},
+		{name: "implausible height", body: `{"height_cm":1700}`, expectedStatus: http.StatusBadRequest},
+		{
+			name:           "username is normalized",
+			body:           `{"username":" Ａｌｉｃｅ "}`,
+			expectedStatus: http.StatusOK,
+			expectedUser: &store.User{
+				ID: 1, Username: "Alice", Email: "alice@example.com", Bio: "old bio",
+				DateOfBirth: &dob, WeightUnit: "kg", DistanceUnit: "km", Timezone: "UTC", HeightCM: &height,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userStore := &fakeUserStore{taken: map[string]bool{"bob": true}}
+			uh := &UserHandler{UserStore: userStore, logger: log.New(io.Discard, "", 0)}
+			current := &store.User{
+				ID: 1, Username: "alice", Email: "alice@example.com", Bio: "old bio",
+				DateOfBirth: &dob, WeightUnit: "kg", DistanceUnit: "km", Timezone: "UTC", HeightCM: &height,
+			}
+
+			req := httptest.NewRequest(http.MethodPatch, "/users/me", strings.NewReader(tt.body))
+			req = middleware.SetUser(req, current)
+			rr := httptest.NewRecorder()
+			uh.HandleUpdateMe(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedUser, userStore.updated)
+			assert.Equal(t, "alice", current.Username)
+		})
+	}
+}
+
+func TestHandleUsernameAvailability(t *testing.T) {
+	uh := &UserHandler{
+		UserStore: &fakeUserStore{taken: map[string]bool{"bob": true}},
+		logger:    log.New(io.Discard, "", 0),
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "missing", query: "", expectedStatus: http.StatusBadRequest, expectedBody: `{"error":"username is required"}`},
+		{name: "available", query: "alice", expectedStatus: http.StatusOK, expectedBody: `{"available":true,"username":"alice"}`},
+		{name: "taken", query: "Bob", expectedStatus: http.StatusOK, expectedBody: `{"available":false,"reason":"username is already taken","username":"Bob"}`},
+		{name: "reserved", query: "root", expectedStatus: http.StatusOK, expectedBody: `{"available":false,"reason":"username is reserved","username":"root"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users/availability?username="+tt.query, nil)
+			rr := httptest.NewRecorder()
+			uh.HandleUsernameAvailability(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestHandleChangePassword(t *testing.T) {
+	const current = "Current-Pass1"
+
+	tests := []struct {
+		name              string
+		bodies            []string
+		lockedUntil       time.Time
+		expectedStatuses  []int
+		expectedPassword  string
+		expectedFailures  int
+		expectedRemaining []string
+	}{
+		{
+			name:              "changes the password and signs out other sessions",
+			bodies:            []string{`{"current_password":"Current-Pass1","new_password":"Brand-New-Pass1"}`},
+			expectedStatuses:  []int{http.StatusOK},
+			expectedPassword:  "Brand-New-Pass1",
+			expectedRemaining: []string{"this-access", "this-refresh", "bob-refresh"},
+		},
+		{
+			name:              "wrong current password",
+			bodies:            []string{`{"current_password":"Wrong-Pass1","new_password":"Brand-New-Pass1"}`},
+			expectedStatuses:  []int{http.StatusBadRequest},
+			expectedPassword:  current,
+			expectedFailures:  1,
+			expectedRemaining: []string{"this-access", "this-refresh", "other-refresh", "reset", "bob-refresh"},
+		},
+		{
+			name: "repeated wrong passwords lock the account",
+			bodies: []string{
+				`{"current_password":"Wrong-Pass1","new_password":"Brand-New-Pass1"}`,
+				`{"current_password":"Wrong-Pass1","new_password":"Brand-New-Pass1"}`,
+				`{"current_password":"Wrong-Pass1","new_password":"Brand-New-Pass1"}`,
+				`{"current_password":"Wrong-Pass1","new_password":"Brand-New-Pass1"}`,
+				`{"current_password":"Wrong-Pass1","new_password":"Brand-New-Pass1"}`,
+				`{"current_password":"Current-Pass1","new_password":"Brand-New-Pass1"}`,
+			},
+			expectedStatuses: []int{
+				http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest,
+				http.StatusTooManyRequests,
+			},
+			expectedPassword:  current,
+			expectedFailures:  5,
+			expectedRemaining: []string{"this-access", "this-refresh", "other-refresh", "reset", "bob-refresh"},
+		},
+		{
+			name: "success resets the failure counter",
+			bodies: []string{
+				`{"current_password":"Wrong-Pass1","new_password":"Brand-New-Pass1"}`,
+				`{"current_password":"Current-Pass1","new_password":"Brand-New-Pass1"}`,
+			},
+			expectedStatuses:  []int{http.StatusBadRequest, http.StatusOK},
+			expectedPassword:  "Brand-New-Pass1",
+			expectedRemaining: []string{"this-access", "this-refresh", "bob-refresh"},
+		},
+		{
+			name:              "locked after failed logins",
+			bodies:            []string{`{"current_password":"Current-Pass1","new_password":"Brand-New-Pass1"}`},
+			lockedUntil:       time.Now().Add(time.Minute),
+			expectedStatuses:  []int{http.StatusTooManyRequests},
+			expectedPassword:  current,
+			expectedRemaining: []string{"this-access", "this-refresh", "other-refresh", "reset", "bob-refresh"},
+		},
+		{
+			name:              "new password breaks policy",
+			bodies:            []string{`{"current_password":"Current-Pass1","new_password":"short"}`},
+			expectedStatuses:  []int{http.StatusBadRequest},
+			expectedPassword:  current,
+			expectedRemaining: []string{"this-access", "this-refresh", "other-refresh", "reset", "bob-refresh"},
+		},
+		{
+			name:              "missing current password",
+			bodies:            []string{`{"new_password":"Brand-New-Pass1"}`},
+			expectedStatuses:  []int{http.StatusBadRequest},
+			expectedPassword:  current,
+			expectedRemaining: []string{"this-access", "this-refresh", "other-refresh", "reset", "bob-refresh"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenStore := &fakeTokenStore{}
+			tokenStore.add("this-access", 1, tokens.ScopeAuth, "this")
+			tokenStore.add("this-refresh", 1, tokens.ScopeRefresh, "this")
+			tokenStore.add("other-refresh", 1, tokens.ScopeRefresh, "other")
+			tokenStore.add("reset", 1, tokens.ScopePasswordReset, "")
+			tokenStore.add("bob-refresh", 2, tokens.ScopeRefresh, "bob")
+			alice := &store.User{ID: 1, Username: "alice"}
+			alice.PasswordHash.Hash = testPasswordHash(t, current)
+			userStore := &fakeUserStore{users: map[int]*store.User{1: alice}}
+			attempts := newFakeLoginAttemptStore()
+			if !tt.lockedUntil.IsZero() {
+				attempts.lockedUntil["user:alice"] = tt.lockedUntil
+			}
+			uh := NewUserHandler(userStore, tokenStore, nil, attempts, nil, log.New(io.Discard, "", 0))
+
+			var statuses []int
+			for _, body := range tt.bodies {
+				req := httptest.NewRequest(http.MethodPut, "/users/me/password", strings.NewReader(body))
+				req = middleware.SetUser(req, alice)
+				req = req.WithContext(context.WithValue(req.Context(), middleware.TokenContextKey, "this-access"))
+				rr := httptest.NewRecorder()
+				uh.HandleChangePassword(rr, req)
+				statuses = append(statuses, rr.Code)
+			}
+
+			assert.Equal(t, tt.expectedStatuses, statuses)
+			assert.Equal(t, tt.expectedFailures, attempts.failures["user:alice"])
+			assert.Equal(t, tt.expectedRemaining, tokenStore.remaining())
+			match, err := passwords.Verify(tt.expectedPassword, userStore.users[1].PasswordHash.Hash)
+			require.NoError(t, err)
+			assert.True(t, match)
+		})
+	}
+}
+
+func TestHandleDeleteMe(t *testing.T) {
+	tests := []struct {
+		name              string
+		userID            int
+		expectedStatus    int
+		expectedRemaining []string
+	}{
+		{
+			name:              "schedules deletion and signs out",
+			userID:            1,
+			expectedStatus:    http.StatusAccepted,
+			expectedRemaining: []string{"reset", "bob-access"},
+		},
+		{
+			name:              "unknown user",
+			userID:            3,
+			expectedStatus:    http.StatusNotFound,
+			expectedRemaining: []string{"alice-access", "alice-refresh", "reset", "bob-access"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenStore := &fakeTokenStore{}
+			tokenStore.add("alice-access", 1, tokens.ScopeAuth, "alice")
+			tokenStore.add("alice-refresh", 1, tokens.ScopeRefresh, "alice")
+			tokenStore.add("reset", 1, tokens.ScopePasswordReset, "")
+			tokenStore.add("bob-access", 2, tokens.ScopeAuth, "bob")
+			userStore := &fakeUserStore{users: map[int]*store.User{1: {ID: 1, Username: "alice"}, 2: {ID: 2, Username: "bob"}}}
+			uh := NewUserHandler(userStore, tokenStore, nil, nil, nil, log.New(io.Discard, "", 0))
+			uh.DeletionGracePeriod = 48 * time.Hour
+
+			req := httptest.NewRequest(http.MethodDelete, "/users/me", nil)
+			req = middleware.SetUser(req, &store.User{ID: tt.userID})
+			rr := httptest.NewRecorder()
+			before := time.Now()
+			uh.HandleDeleteMe(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedRemaining, tokenStore.remaining())
+			assert.Nil(t, userStore.users[2].DeletionScheduledFor)
+			if tt.expectedStatus != http.StatusAccepted {
+				return
+			}
+			scheduled := userStore.users[1].DeletionScheduledFor
+			require.NotNil(t, scheduled)
+			assert.WithinDuration(t, before.Add(48*time.Hour), *scheduled, time.Second)
+			var body struct {
+				DeletionScheduledFor time.Time `json:"deletion_scheduled_for"`
+			}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+			assert.True(t, scheduled.Equal(body.DeletionScheduledFor))
+		})
+	}
+}
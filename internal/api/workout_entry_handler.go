@@ -0,0 +1,276 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+	"github.com/alireza-akbarzadeh/fem_project/internal/validation"
+	"github.com/go-chi/chi/v5"
+)
+
+// entryReadOnlyFields cannot be changed through a merge patch.
+var entryReadOnlyFields = []string{"id", "workout_id", "created_at"}
+
+// readEntryParams reads the workout and entry ids from the URL and checks
+// that the workout belongs to the authenticated user, writing an error
+// response and returning false otherwise.
+func (wh *WorkoutHandler) readEntryParams(w http.ResponseWriter, r *http.Request) (int64, int64, bool) {
+	workoutID, err := utils.ReadIDParams(r)
+	if err != nil {
+		wh.logger.Printf("failed to read workout id from params:%v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
+		return 0, 0, false
+	}
+	entryID, err := strconv.ParseInt(chi.URLParam(r, "entryId"), 10, 64)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid entry id"})
+		return 0, 0, false
+	}
+	if !wh.authorizeOwner(w, r, workoutID) {
+		return 0, 0, false
+	}
+	return workoutID, entryID, true
+}
+
+// @Summary      List workout entries
+// @Description  Returns the entries of a workout in order.
+// @Tags         Workout Entries
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param id path int true "Workout ID"
+//
+// @Success      200 {object} utils.Envelope "Entries of the workout"
+// @Failure      401 {object} utils.Envelope "Not authenticated"
+// @Failure      403 {object} utils.Envelope "Workout belongs to another user"
+// @Failure      404 {object} utils.Envelope "Workout not found"
+// @Failure      500 {object} utils.Envelope "Server error while fetching entries"
+//
+// @Router       /workouts/{id}/entries [get]
+func (wh *WorkoutHandler) HandleListEntries(w http.ResponseWriter, r *http.Request) {
+	workoutID, err := utils.ReadIDParams(r)
+	if err != nil {
+		wh.logger.Printf("failed to read workout id from params:%v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
+		return
+	}
+	if !wh.authorizeOwner(w, r, workoutID) {
+		return
+	}
+
+	entries, err := wh.workoutStore.GetWorkoutEntries(workoutID)
+	if err != nil {
+		wh.logger.Printf("failed to get workout entries:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch entries"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"entries": entries})
+}
+
+// @Summary      Get a workout entry
+// @Tags         Workout Entries
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param id      path int true "Workout ID"
+// @Param entryId path int true "Entry ID"
+//
+// @Success      200 {object} utils.Envelope "The entry"
+// @Failure      401 {object} utils.Envelope "Not authenticated"
+// @Failure      403 {object} utils.Envelope "Workout belongs to another user"
+// @Failure      404 {object} utils.Envelope "Workout or entry not found"
+// @Failure      500 {object} utils.Envelope "Server error while fetching the entry"
+//
+// @Router       /workouts/{id}/entries/{entryId} [get]
+func (wh *WorkoutHandler) HandleGetEntry(w http.ResponseWriter, r *http.Request) {
+	workoutID, entryID, ok := wh.readEntryParams(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := wh.workoutStore.GetWorkoutEntry(workoutID, entryID)
+	if err != nil {
+		wh.logger.Printf("failed to get workout entry:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch entry"})
+		return
+	}
+	if entry == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout entry not found"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"entry": entry})
+}
+
+// @Summary      Add a workout entry
+// @Description  Adds an entry to the workout. With an order_index it is inserted at that
+// @Description  1-based position and later entries move down; otherwise it is appended.
+// @Tags         Workout Entries
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param id    path int                true "Workout ID"
+// @Param entry body store.WorkoutEntry true "Entry to add"
+//
+// @Success      201 {object} utils.Envelope "Created entry"
+// @Failure      400 {object} utils.Envelope "Invalid entry"
+// @Failure      401 {object} utils.Envelope "Not authenticated"
+// @Failure      403 {object} utils.Envelope "Workout belongs to another user"
+// @Failure      404 {object} utils.Envelope "Workout not found"
+// @Failure      500 {object} utils.Envelope "Server error while adding the entry"
+//
+// @Router       /workouts/{id}/entries [post]
+func (wh *WorkoutHandler) HandleCreateEntry(w http.ResponseWriter, r *http.Request) {
+	workoutID, err := utils.ReadIDParams(r)
+	if err != nil {
+		wh.logger.Printf("failed to read workout id from params:%v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
+		return
+	}
+	if !wh.authorizeOwner(w, r, workoutID) {
+		return
+	}
+
+	var entry store.WorkoutEntry
+	err = json.NewDecoder(r.Body).Decode(&entry)
+	if err != nil {
+		wh.logger.Printf("failed to decode workout entry from request body:%v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+	entry.ID = 0
+	entry.WorkoutID = int(workoutID)
+	v := validation.New()
+	validateEntry(v, "", &entry)
+	if !v.Valid() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid entry", "errors": v.Errors})
+		return
+	}
+
+	err = wh.workoutStore.CreateWorkoutEntry(&entry)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return
+	}
+	if err != nil {
+		wh.logger.Printf("failed to create workout entry:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create entry"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"entry": entry})
+}
+
+// @Summary      Update a workout entry
+// @Description  Applies an RFC 7396 JSON merge patch to the entry. Changing order_index moves
+// @Description  the entry to that 1-based position and shifts the entries in between.
+// @Tags         Workout Entries
+// @Accept       json
+// @Accept       application/merge-patch+json
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param id      path int                true "Workout ID"
+// @Param entryId path int                true "Entry ID"
+// @Param patch   body store.WorkoutEntry true "Merge patch with the members to change"
+//
+// @Success      200 {object} utils.Envelope "Updated entry"
+// @Failure      400 {object} utils.Envelope "Malformed patch or invalid merged entry"
+// @Failure      401 {object} utils.Envelope "Not authenticated"
+// @Failure      403 {object} utils.Envelope "Workout belongs to another user"
+// @Failure      404 {object} utils.Envelope "Workout or entry not found"
+// @Failure      415 {object} utils.Envelope "Unsupported content type"
+// @Failure      500 {object} utils.Envelope "Server error while updating the entry"
+//
+// @Router       /workouts/{id}/entries/{entryId} [patch]
+func (wh *WorkoutHandler) HandlePatchEntry(w http.ResponseWriter, r *http.Request) {
+	workoutID, entryID, ok := wh.readEntryParams(w, r)
+	if !ok {
+		return
+	}
+	patch, _, ok := readMergePatch(w, r, wh.logger, entryReadOnlyFields)
+	if !ok {
+		return
+	}
+
+	current, err := wh.workoutStore.GetWorkoutEntry(workoutID, entryID)
+	if err != nil {
+		wh.logger.Printf("failed to get workout entry:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch entry"})
+		return
+	}
+	if current == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout entry not found"})
+		return
+	}
+
+	var entry store.WorkoutEntry
+	err = mergeInto(current, patch, &entry)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": fmt.Sprintf("invalid entry: %v", err)})
+		return
+	}
+	entry.ID = current.ID
+	entry.WorkoutID = current.WorkoutID
+	entry.CreatedAt = current.CreatedAt
+	if entry.OrderIndex == 0 {
+		entry.OrderIndex = current.OrderIndex
+	}
+	v := validation.New()
+	validateEntry(v, "", &entry)
+	if !v.Valid() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid entry", "errors": v.Errors})
+		return
+	}
+
+	err = wh.workoutStore.UpdateWorkoutEntry(&entry)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout entry not found"})
+		return
+	}
+	if err != nil {
+		wh.logger.Printf("failed to update workout entry:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update entry"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"entry": entry})
+}
+
+// @Summary      Delete a workout entry
+// @Description  Removes the entry; later entries move up to close the gap.
+// @Tags         Workout Entries
+// @Security     BearerAuth
+//
+// @Param id      path int true "Workout ID"
+// @Param entryId path int true "Entry ID"
+//
+// @Success      204 "Entry deleted"
+// @Failure      401 {object} utils.Envelope "Not authenticated"
+// @Failure      403 {object} utils.Envelope "Workout belongs to another user"
+// @Failure      404 {object} utils.Envelope "Workout or entry not found"
+// @Failure      500 {object} utils.Envelope "Server error while deleting the entry"
+//
+// @Router       /workouts/{id}/entries/{entryId} [delete]
+func (wh *WorkoutHandler) HandleDeleteEntry(w http.ResponseWriter, r *http.Request) {
+	workoutID, entryID, ok := wh.readEntryParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := wh.workoutStore.DeleteWorkoutEntry(workoutID, entryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout entry not found"})
+		return
+	}
+	if err != nil {
+		wh.logger.Printf("failed to delete workout entry:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to delete entry"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func (f *fakeWorkoutStore) GetWorkoutEntry(workoutID, entryID int64) (*store.WorkoutEntry, error) {
+	workout := f.find(workoutID)
+	if workout == nil {
+		return nil, nil
+	}
+	for _, entry := range workout.Entries {
+		if int64(entry.ID) == entryID {
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// placeEntry puts entry at its 1-based OrderIndex, clamped to the list, and
+// renumbers the entries the way the Postgres store shifts them.
+func placeEntry(entries []store.WorkoutEntry, entry store.WorkoutEntry) []store.WorkoutEntry {
+	position := min(max(entry.OrderIndex, 1), len(entries)+1)
+	entries = slices.Insert(entries, position-1, entry)
+	renumberEntries(entries)
+	return entries
+}
+
+func renumberEntries(entries []store.WorkoutEntry) {
+	for i := range entries {
+		entries[i].OrderIndex = i + 1
+	}
+}
+
+func (f *fakeWorkoutStore) CreateWorkoutEntry(entry *store.WorkoutEntry) error {
+	workout := f.find(int64(entry.WorkoutID))
+	entry.ID = 100 + len(workout.Entries)
+	if entry.OrderIndex < 1 || entry.OrderIndex > len(workout.Entries)+1 {
+		entry.OrderIndex = len(workout.Entries) + 1
+	}
+	workout.Entries = placeEntry(workout.Entries, *entry)
+	return nil
+}
+
+func (f *fakeWorkoutStore) UpdateWorkoutEntry(entry *store.WorkoutEntry) error {
+	workout := f.find(int64(entry.WorkoutID))
+	for i := range workout.Entries {
+		if workout.Entries[i].ID == entry.ID {
+			entry.OrderIndex = min(max(entry.OrderIndex, 1), len(workout.Entries))
+			workout.Entries = placeEntry(slices.Delete(workout.Entries, i, i+1), *entry)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (f *fakeWorkoutStore) DeleteWorkoutEntry(workoutID, entryID int64) error {
+	workout := f.find(workoutID)
+	for i := range workout.Entries {
+		if int64(workout.Entries[i].ID) == entryID {
+			workout.Entries = slices.Delete(workout.Entries, i, i+1)
+			renumberEntries(workout.Entries)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func TestWorkoutEntryHandlers(t *testing.T) {
+	reps, seconds := 10, 30
+
+	tests := []struct {
+		name            string
+		method          string
+		path            string
+		body            string
+		expectedStatus  int
+		expectedEntries []store.WorkoutEntry
+	}{
+		{
+			name:           "add entry",
+			method:         http.MethodPost,
+			path:           "/workouts/1/entries",
+			body:           `{"exercise_name":"Plank","sets":3,"reps":10,"duration_seconds":30}`,
+			expectedStatus: http.StatusCreated,
+			expectedEntries: []store.WorkoutEntry{
+				{ID: 7, WorkoutID: 1, ExerciseName: "Squat", Sets: 5, OrderIndex: 1},
+				{ID: 8, WorkoutID: 1, ExerciseName: "Lunge", Sets: 3, OrderIndex: 2},
+				{ID: 102, WorkoutID: 1, ExerciseName: "Plank", Sets: 3, Reps: &reps, DurationSeconds: &seconds, OrderIndex: 3},
+			},
+		},
+		{
+			name:           "add entry at a position",
+			method:         http.MethodPost,
+			path:           "/workouts/1/entries",
+			body:           `{"exercise_name":"Plank","sets":3,"order_index":1}`,
+			expectedStatus: http.StatusCreated,
+			expectedEntries: []store.WorkoutEntry{
+				{ID: 102, WorkoutID: 1, ExerciseName: "Plank", Sets: 3, OrderIndex: 1},
+				{ID: 7, WorkoutID: 1, ExerciseName: "Squat", Sets: 5, OrderIndex: 2},
+				{ID: 8, WorkoutID: 1, ExerciseName: "Lunge", Sets: 3, OrderIndex: 3},
+			},
+		},
+		{
+			name:           "add invalid entry",
+			method:         http.MethodPost,
+			path:           "/workouts/1/entries",
+			body:           `{"exercise_name":"","sets":0}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "edit entry keeps its position",
+			method:         http.MethodPatch,
+			path:           "/workouts/1/entries/8",
+			body:           `{"sets":4}`,
+			expectedStatus: http.StatusOK,
+			expectedEntries: []store.WorkoutEntry{
+				{ID: 7, WorkoutID: 1, ExerciseName: "Squat", Sets: 5, OrderIndex: 1},
+				{ID: 8, WorkoutID: 1, ExerciseName: "Lunge", Sets: 4, OrderIndex: 2},
+			},
+		},
+		{
+			name:           "move entry",
+			method:         http.MethodPatch,
+			path:           "/workouts/1/entries/8",
+			body:           `{"order_index":1}`,
+			expectedStatus: http.StatusOK,
+			expectedEntries: []store.WorkoutEntry{
+				{ID: 8, WorkoutID: 1, ExerciseName: "Lunge", Sets: 3, OrderIndex: 1},
+				{ID: 7, WorkoutID: 1, ExerciseName: "Squat", Sets: 5, OrderIndex: 2},
+			},
+		},
+		{name: "edit read-only field", method: http.MethodPatch, path: "/workouts/1/entries/8", body: `{"workout_id":2}`, expectedStatus: http.StatusBadRequest},
+		{name: "edit missing entry", method: http.MethodPatch, path: "/workouts/1/entries/99", body: `{"sets":4}`, expectedStatus: http.StatusNotFound},
+		{name: "get entry", method: http.MethodGet, path: "/workouts/1/entries/7", expectedStatus: http.StatusOK},
+		{name: "invalid entry id", method: http.MethodGet, path: "/workouts/1/entries/abc", expectedStatus: http.StatusBadRequest},
+		{
+			name:           "delete entry",
+			method:         http.MethodDelete,
+			path:           "/workouts/1/entries/7",
+			expectedStatus: http.StatusNoContent,
+			expectedEntries: []store.WorkoutEntry{
+				{ID: 8, WorkoutID: 1, ExerciseName: "Lunge", Sets: 3, OrderIndex: 1},
+			},
+		},
+		{name: "delete missing entry", method: http.MethodDelete, path: "/workouts/1/entries/99", expectedStatus: http.StatusNotFound},
+		{name: "other user's workout", method: http.MethodGet, path: "/workouts/2/entries", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeWorkoutStore{workouts: []*store.Workout{
+				{ID: 1, UserID: 1, Title: "Leg day", DurationMinutes: 45, Entries: []store.WorkoutEntry{
+					{ID: 7, WorkoutID: 1, ExerciseName: "Squat", Sets: 5, OrderIndex: 1},
+					{ID: 8, WorkoutID: 1, ExerciseName: "Lunge", Sets: 3, OrderIndex: 2},
+				}},
+				{ID: 2, UserID: 2, Title: "Not mine", DurationMinutes: 30},
+			}}
+			wh := NewWorkoutHandler(fake, log.New(io.Discard, "", 0))
+
+			r := chi.NewRouter()
+			r.Get("/workouts/{id}/entries", wh.HandleListEntries)
+			r.Post("/workouts/{id}/entries", wh.HandleCreateEntry)
+			r.Get("/workouts/{id}/entries/{entryId}", wh.HandleGetEntry)
+			r.Patch("/workouts/{id}/entries/{entryId}", wh.HandlePatchEntry)
+			r.Delete("/workouts/{id}/entries/{entryId}", wh.HandleDeleteEntry)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req = middleware.SetUser(req, &store.User{ID: 1})
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code, rr.Body.String())
+			if tt.expectedEntries != nil {
+				assert.Equal(t, tt.expectedEntries, fake.workouts[0].Entries)
+			}
+		})
+	}
+}
@@ -1,17 +1,28 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
-
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
 	"github.com/alireza-akbarzadeh/fem_project/internal/store"
 	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+	"github.com/alireza-akbarzadeh/fem_project/internal/validation"
 	"github.com/go-chi/chi/v5"
 )
 
+const maxSearchQueryLength = 200
+
 type WorkoutHandler struct {
 	workoutStore store.WorkoutStore
 	logger       *log.Logger
@@ -24,14 +35,201 @@ func NewWorkoutHandler(workoutStore store.WorkoutStore, logger *log.Logger) *Wor
 	}
 }
 
+// authorizeOwner writes a 404 or 403 response and returns false unless the
+// workout exists and belongs to the authenticated user.
+func (wh *WorkoutHandler) authorizeOwner(w http.ResponseWriter, r *http.Request, workoutID int64) bool {
+	currentUser := middleware.GetUser(r)
+	ownerID, err := wh.workoutStore.GetWorkoutOwner(workoutID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+			return false
+		}
+		wh.logger.Printf("failed to get workout owner:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return false
+	}
+	if ownerID != currentUser.ID {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "you are not authorized to access this workout"})
+		return false
+	}
+	return true
+}
+
+// readWorkoutFilter parses the list query string of GET /workouts. Every
+// malformed parameter is reported in the returned validator.
+func readWorkoutFilter(query url.Values) (store.WorkoutFilter, *validation.Validator) {
+	v := validation.New()
+	filter := store.WorkoutFilter{
+		Title:    query.Get("title"),
+		Sort:     store.DefaultWorkoutSort,
+		Page:     1,
+		PageSize: store.DefaultWorkoutPageSize,
+		Cursor:   query.Get("cursor"),
+	}
+
+	readInt := func(key string, min, max int) *int {
+		raw := query.Get(key)
+		if raw == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			v.AddError(key, "must be an integer")
+			return nil
+		}
+		if n < min || n > max {
+			v.AddError(key, fmt.Sprintf("must be between %d and %d", min, max))
+			return nil
+		}
+		return &n
+	}
+	readTime := func(key string) *time.Time {
+		raw := query.Get(key)
+		if raw == "" {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, raw)
+		}
+		if err != nil {
+			v.AddError(key, "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+			return nil
+		}
+		return &t
+	}
+
+	if page := readInt("page", 1, math.MaxInt32); page != nil {
+		filter.Page = *page
+	}
+	if size := readInt("page_size", 1, store.MaxWorkoutPageSize); size != nil {
+		filter.PageSize = *size
+	}
+	if sort := query.Get("sort"); sort != "" {
+		filter.Sort = sort
+		if _, ok := store.WorkoutSortFields[filter.SortColumn()]; !ok {
+			v.AddError("sort", "unsupported sort field")
+		}
+	}
+	if filter.Cursor != "" && query.Has("page") {
+		v.AddError("cursor", "cannot be combined with page")
+	}
+	filter.MinDuration = readInt("min_duration", 0, math.MaxInt32)
+	filter.MaxDuration = readInt("max_duration", 0, math.MaxInt32)
+	filter.MinCalories = readInt("min_calories", 0, math.MaxInt32)
+	filter.MaxCalories = readInt("max_calories", 0, math.MaxInt32)
+	filter.CreatedAfter = readTime("created_after")
+	filter.CreatedBefore = readTime("created_before")
+
+	if filter.MinDuration != nil && filter.MaxDuration != nil && *filter.MinDuration > *filter.MaxDuration {
+		v.AddError("min_duration", "must not be greater than max_duration")
+	}
+	if filter.MinCalories != nil && filter.MaxCalories != nil && *filter.MinCalories > *filter.MaxCalories {
+		v.AddError("min_calories", "must not be greater than max_calories")
+	}
+	if filter.CreatedAfter != nil && filter.CreatedBefore != nil && !filter.CreatedAfter.Before(*filter.CreatedBefore) {
+		v.AddError("created_after", "must be before created_before")
+	}
+	return filter, v
+}
+
+// @Summary      List workouts
+// @Description  Returns the authenticated user's workouts, one page at a time. Use either
+// @Description  page/page_size or follow metadata.next_cursor; a cursor is bound to the sort
+// @Description  it was issued for.
+// @Tags         Workouts
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param page           query int    false "1-based page number" default(1)
+// @Param page_size      query int    false "Results per page (1-100)" default(20)
+// @Param cursor         query string false "Opaque cursor from metadata.next_cursor"
+// @Param sort           query string false "id, title, duration_minutes, calories_burned or created_at; prefix with - for descending" default(-created_at)
+// @Param title          query string false "Case-insensitive title substring"
+// @Param min_duration   query int    false "Minimum duration in minutes"
+// @Param max_duration   query int    false "Maximum duration in minutes"
+// @Param min_calories   query int    false "Minimum calories burned"
+// @Param max_calories   query int    false "Maximum calories burned"
+// @Param created_after  query string false "Created at or after (RFC 3339 or YYYY-MM-DD)"
+// @Param created_before query string false "Created before (RFC 3339 or YYYY-MM-DD)"
+//
+// @Success      200 {object} utils.Envelope "Workouts and pagination metadata"
+// @Failure      400 {object} utils.Envelope "Invalid query parameters or cursor"
+// @Failure      401 {object} utils.Envelope "Not authenticated"
+// @Failure      500 {object} utils.Envelope "Server error while fetching workouts"
+//
+// @Router       /workouts [get]
 func (wh *WorkoutHandler) GetAllWorkouts(w http.ResponseWriter, r *http.Request) {
-	result, err := wh.workoutStore.GetWorkouts()
+	filter, v := readWorkoutFilter(r.URL.Query())
+	if !v.Valid() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters", "errors": v.Errors})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	result, metadata, err := wh.workoutStore.GetWorkouts(currentUser.ID, filter)
 	if err != nil {
+		if errors.Is(err, store.ErrInvalidCursor) {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid or expired cursor"})
+			return
+		}
 		wh.logger.Printf("failed to get workouts:%v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch workouts"})
 		return
 	}
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workouts": result})
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workouts": result, "metadata": metadata})
+}
+
+// @Summary      Search workouts
+// @Description  Full-text search over the title and description of the authenticated user's
+// @Description  workouts and the exercise names and notes of their entries. Supports quoted
+// @Description  phrases, "or" and -term. Results are ranked best first and carry highlighted
+// @Description  snippets, HTML-escaped with matches wrapped in <mark>.
+// @Tags         Workouts
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param q     query string true  "Search query"
+// @Param limit query int    false "Maximum number of results (1-50)" default(20)
+//
+// @Success      200 {object} utils.Envelope "Ranked search results"
+// @Failure      400 {object} utils.Envelope "Missing or invalid query parameters"
+// @Failure      401 {object} utils.Envelope "Not authenticated"
+// @Failure      500 {object} utils.Envelope "Server error while searching"
+//
+// @Router       /workouts/search [get]
+func (wh *WorkoutHandler) HandleSearchWorkouts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	v := validation.New()
+
+	q := strings.TrimSpace(query.Get("q"))
+	switch {
+	case q == "":
+		v.AddError("q", "must be provided")
+	case utf8.RuneCountInString(q) > maxSearchQueryLength:
+		v.AddError("q", fmt.Sprintf("must not be more than %d characters long", maxSearchQueryLength))
+	}
+	limit := store.DefaultWorkoutSearchLimit
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > store.MaxWorkoutSearchLimit {
+			v.AddError("limit", fmt.Sprintf("must be an integer between 1 and %d", store.MaxWorkoutSearchLimit))
+		}
+		limit = n
+	}
+	if !v.Valid() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters", "errors": v.Errors})
+		return
+	}
+
+	results, err := wh.workoutStore.SearchWorkouts(middleware.GetUser(r).ID, q, limit)
+	if err != nil {
+		wh.logger.Printf("failed to search workouts:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to search workouts"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"results": results})
 }
 
 func (wh *WorkoutHandler) Get(w http.ResponseWriter, r *http.Request) {
@@ -41,7 +239,10 @@ func (wh *WorkoutHandler) Get(w http.ResponseWriter, r *http.Request) {
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
 		return
 	}
-	workout, err := wh.workoutStore.GetWorkoutByID(workoutID)
+	if !wh.authorizeOwner(w, r, workoutID) {
+		return
+	}
+	workout, err := wh.workoutStore.GetWorkoutByID(workoutID, middleware.GetUser(r).ID)
 	if err != nil {
 		wh.logger.Printf("failed to get workout by id:%v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch workout"})
@@ -51,6 +252,12 @@ func (wh *WorkoutHandler) Get(w http.ResponseWriter, r *http.Request) {
 		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
 		return
 	}
+	etag := workoutETag(workout)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": workout})
 }
 
@@ -62,12 +269,14 @@ func (wh *WorkoutHandler) Insert(w http.ResponseWriter, r *http.Request) {
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
 		return
 	}
+	workout.UserID = middleware.GetUser(r).ID
 	createdWorkout, err := wh.workoutStore.CreateWorkout(&workout)
 	if err != nil {
 		wh.logger.Printf("failed to create workout:%v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create workout"})
 		return
 	}
+	w.Header().Set("ETag", workoutETag(createdWorkout))
 	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"workout": createdWorkout})
 }
 
@@ -82,7 +291,10 @@ func (wh *WorkoutHandler) HandleGetWorkoutById(w http.ResponseWriter, r *http.Re
 		http.Error(w, "invalid workout id", http.StatusBadRequest)
 		return
 	}
-	workout, err := wh.workoutStore.GetWorkoutByID(workoutID)
+	if !wh.authorizeOwner(w, r, workoutID) {
+		return
+	}
+	workout, err := wh.workoutStore.GetWorkoutByID(workoutID, middleware.GetUser(r).ID)
 	if err != nil {
 		http.Error(w, "failed to fetch workout", http.StatusInternalServerError)
 		return
@@ -91,6 +303,12 @@ func (wh *WorkoutHandler) HandleGetWorkoutById(w http.ResponseWriter, r *http.Re
 		http.NotFound(w, r)
 		return
 	}
+	etag := workoutETag(workout)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(workout)
 }
@@ -103,6 +321,13 @@ func (wh *WorkoutHandler) HandleUpdateWorkout(w http.ResponseWriter, r *http.Req
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
 		return
 	}
+	if !wh.authorizeOwner(w, r, workoutID) {
+		return
+	}
+	version, ok := wh.ifMatchVersion(w, r, workoutID)
+	if !ok {
+		return
+	}
 
 	var workout store.Workout
 	err = json.NewDecoder(r.Body).Decode(&workout)
@@ -113,17 +338,204 @@ func (wh *WorkoutHandler) HandleUpdateWorkout(w http.ResponseWriter, r *http.Req
 	}
 
 	workout.ID = int(workoutID)
+	workout.UserID = middleware.GetUser(r).ID
+	workout.Version = version
 
 	err = wh.workoutStore.UpdateWorkout(&workout)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return
+	}
+	if errors.Is(err, store.ErrEditConflict) {
+		writePreconditionFailed(w, nil)
+		return
+	}
+	if errors.Is(err, store.ErrWorkoutEntryNotFound) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "entries reference an entry that is not part of this workout"})
+		return
+	}
 	if err != nil {
 		wh.logger.Printf("failed to update workout:%v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update workout"})
 		return
 	}
 
+	w.Header().Set("ETag", workoutETag(&workout))
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": workout})
 }
 
+const (
+	maxWorkoutTitleLength = 255
+	maxExerciseNameLength = 255
+	maxEntryWeight        = 999.99
+)
+
+// workoutReadOnlyFields cannot be changed through a merge patch.
+var workoutReadOnlyFields = []string{"id", "user_id", "created_at", "updated_at", "version"}
+
+// validateWorkout checks a workout against the constraints of the workouts
+// and workouts_entries tables.
+func validateWorkout(workout *store.Workout) *validation.Validator {
+	v := validation.New()
+	switch {
+	case strings.TrimSpace(workout.Title) == "":
+		v.AddError("title", "must be provided")
+	case utf8.RuneCountInString(workout.Title) > maxWorkoutTitleLength:
+		v.AddError("title", fmt.Sprintf("must not be more than %d characters long", maxWorkoutTitleLength))
+	}
+	if workout.DurationMinutes <= 0 {
+		v.AddError("duration_minutes", "must be greater than zero")
+	}
+	if workout.CaloriesBurned < 0 {
+		v.AddError("calories_burned", "must not be negative")
+	}
+	for i := range workout.Entries {
+		validateEntry(v, fmt.Sprintf("entries[%d].", i), &workout.Entries[i])
+	}
+	return v
+}
+
+// validateEntry adds the problems with entry to v, prefixing field names
+// with prefix.
+func validateEntry(v *validation.Validator, prefix string, entry *store.WorkoutEntry) {
+	switch {
+	case strings.TrimSpace(entry.ExerciseName) == "":
+		v.AddError(prefix+"exercise_name", "must be provided")
+	case utf8.RuneCountInString(entry.ExerciseName) > maxExerciseNameLength:
+		v.AddError(prefix+"exercise_name", fmt.Sprintf("must not be more than %d characters long", maxExerciseNameLength))
+	}
+	if entry.Sets <= 0 {
+		v.AddError(prefix+"sets", "must be greater than zero")
+	}
+	if (entry.Reps == nil) != (entry.DurationSeconds == nil) {
+		v.AddError(prefix+"reps", "must be provided together with duration_seconds")
+	}
+	if entry.Reps != nil && *entry.Reps < 0 {
+		v.AddError(prefix+"reps", "must not be negative")
+	}
+	if entry.DurationSeconds != nil && *entry.DurationSeconds < 0 {
+		v.AddError(prefix+"duration_seconds", "must not be negative")
+	}
+	if entry.Weight != nil && (*entry.Weight < 0 || *entry.Weight > maxEntryWeight) {
+		v.AddError(prefix+"weight", fmt.Sprintf("must be between 0 and %.2f", maxEntryWeight))
+	}
+	if entry.OrderIndex < 0 {
+		v.AddError(prefix+"order_index", "must not be negative")
+	}
+}
+
+// @Summary      Partially update a workout
+// @Description  Applies an RFC 7396 JSON merge patch to the workout. Only the supplied members
+// @Description  change; null clears a member, and a supplied entries array replaces all
+// @Description  entries. The merged workout must still be valid.
+// @Tags         Workouts
+// @Accept       json
+// @Accept       application/merge-patch+json
+// @Produce      json
+// @Security     BearerAuth
+//
+// @Param id       path   int           true  "Workout ID"
+// @Param If-Match header string        false "ETag of the version the patch was written against"
+// @Param patch    body   store.Workout true  "Merge patch with the members to change"
+//
+// @Success      200 {object} utils.Envelope "Updated workout"
+// @Failure      400 {object} utils.Envelope "Malformed patch or invalid merged workout"
+// @Failure      401 {object} utils.Envelope "Not authenticated"
+// @Failure      403 {object} utils.Envelope "Workout belongs to another user"
+// @Failure      404 {object} utils.Envelope "Workout not found"
+// @Failure      409 {object} utils.Envelope "Workout changed while the patch was applied"
+// @Failure      412 {object} utils.Envelope "If-Match does not match the current version"
+// @Failure      415 {object} utils.Envelope "Unsupported content type"
+// @Failure      500 {object} utils.Envelope "Server error while updating the workout"
+//
+// @Router       /workouts/{id} [patch]
+func (wh *WorkoutHandler) HandlePatchWorkout(w http.ResponseWriter, r *http.Request) {
+	workoutID, err := utils.ReadIDParams(r)
+	if err != nil {
+		wh.logger.Printf("failed to read workout id from params:%v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
+		return
+	}
+	if !wh.authorizeOwner(w, r, workoutID) {
+		return
+	}
+	patch, members, ok := readMergePatch(w, r, wh.logger, workoutReadOnlyFields)
+	if !ok {
+		return
+	}
+
+	current, err := wh.workoutStore.GetWorkoutByID(workoutID, middleware.GetUser(r).ID)
+	if err != nil {
+		wh.logger.Printf("failed to get workout by id:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch workout"})
+		return
+	}
+	if current == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return
+	}
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && !etagMatches(ifMatch, workoutETag(current), false) {
+		writePreconditionFailed(w, current)
+		return
+	}
+
+	var workout store.Workout
+	err = mergeInto(current, patch, &workout)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": fmt.Sprintf("invalid workout: %v", err)})
+		return
+	}
+	workout.ID = current.ID
+	workout.UserID = current.UserID
+	workout.CreatedAt = current.CreatedAt
+	// the patch was merged into this version, so it must not be applied on
+	// top of anything newer
+	workout.Version = current.Version
+	if v := validateWorkout(&workout); !v.Valid() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout", "errors": v.Errors})
+		return
+	}
+
+	// entries are only rewritten when the patch replaces them, so that
+	// their ids stay stable otherwise
+	if _, ok := members["entries"]; ok {
+		err = wh.workoutStore.UpdateWorkout(&workout)
+	} else {
+		err = wh.workoutStore.UpdateWorkoutDetails(&workout)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return
+	}
+	if errors.Is(err, store.ErrEditConflict) {
+		if ifMatch != "" {
+			writePreconditionFailed(w, nil)
+			return
+		}
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "the workout was modified by another request, please retry"})
+		return
+	}
+	if errors.Is(err, store.ErrWorkoutEntryNotFound) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "entries reference an entry that is not part of this workout"})
+		return
+	}
+	if err != nil {
+		wh.logger.Printf("failed to patch workout:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to update workout"})
+		return
+	}
+
+	updated, err := wh.workoutStore.GetWorkoutByID(workoutID, middleware.GetUser(r).ID)
+	if err != nil || updated == nil {
+		wh.logger.Printf("failed to reload workout:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to fetch workout"})
+		return
+	}
+	w.Header().Set("ETag", workoutETag(updated))
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": updated})
+}
+
 func (wh *WorkoutHandler) HandleDeleteWorkout(w http.ResponseWriter, r *http.Request) {
 	workoutID, err := utils.ReadIDParams(r)
 	if err != nil {
@@ -131,8 +543,24 @@ func (wh *WorkoutHandler) HandleDeleteWorkout(w http.ResponseWriter, r *http.Req
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
 		return
 	}
+	if !wh.authorizeOwner(w, r, workoutID) {
+		return
+	}
 
-	err = wh.workoutStore.DeleteWorkout(workoutID)
+	version, ok := wh.ifMatchVersion(w, r, workoutID)
+	if !ok {
+		return
+	}
+
+	err = wh.workoutStore.DeleteWorkout(workoutID, middleware.GetUser(r).ID, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return
+	}
+	if errors.Is(err, store.ErrEditConflict) {
+		writePreconditionFailed(w, nil)
+		return
+	}
 	if err != nil {
 		wh.logger.Printf("failed to delete workout:%v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to delete workout"})
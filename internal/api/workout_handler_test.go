@@ -0,0 +1,367 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestReadWorkoutFilter(t *testing.T) {
+	intPtr := func(n int) *int { return &n }
+	may := time.Date(2025, time.May, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedFilter store.WorkoutFilter
+		expectedErrors []string
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			expectedFilter: store.WorkoutFilter{
+				Sort: store.DefaultWorkoutSort, Page: 1, PageSize: store.DefaultWorkoutPageSize,
+			},
+		},
+		{
+			name:  "filters and sort",
+			query: "title=leg&min_duration=10&max_duration=60&min_calories=100&created_after=2025-05-01&sort=title&page=2&page_size=50",
+			expectedFilter: store.WorkoutFilter{
+				Title: "leg", MinDuration: intPtr(10), MaxDuration: intPtr(60), MinCalories: intPtr(100),
+				CreatedAfter: &may, Sort: "title", Page: 2, PageSize: 50,
+			},
+		},
+		{
+			name:           "invalid values",
+			query:          "page=0&page_size=101&min_duration=abc&sort=password&created_before=yesterday",
+			expectedErrors: []string{"page", "page_size", "min_duration", "sort", "created_before"},
+		},
+		{
+			name:           "inverted ranges",
+			query:          "min_duration=60&max_duration=10&min_calories=5&max_calories=1&created_after=2025-05-02&created_before=2025-05-01",
+			expectedErrors: []string{"min_duration", "min_calories", "created_after"},
+		},
+		{
+			name:           "cursor with page",
+			query:          "cursor=abc&page=2",
+			expectedErrors: []string{"cursor"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			assert.NoError(t, err)
+
+			filter, v := readWorkoutFilter(query)
+			if tt.expectedErrors == nil {
+				assert.True(t, v.Valid(), "unexpected errors: %v", v.Errors)
+				assert.Equal(t, tt.expectedFilter, filter)
+				return
+			}
+			for _, field := range tt.expectedErrors {
+				assert.Contains(t, v.Errors, field)
+			}
+			assert.Len(t, v.Errors, len(tt.expectedErrors))
+		})
+	}
+}
+
+type fakeWorkoutStore struct {
+	store.WorkoutStore
+	workouts []*store.Workout
+}
+
+func (f *fakeWorkoutStore) find(id int64) *store.Workout {
+	for _, workout := range f.workouts {
+		if int64(workout.ID) == id {
+			return workout
+		}
+	}
+	return nil
+}
+
+func (f *fakeWorkoutStore) GetWorkoutOwner(id int64) (int, error) {
+	workout := f.find(id)
+	if workout == nil {
+		return 0, sql.ErrNoRows
+	}
+	return workout.UserID, nil
+}
+
+func (f *fakeWorkoutStore) GetWorkoutByID(id int64, userID int) (*store.Workout, error) {
+	workout := f.find(id)
+	if workout == nil || workout.UserID != userID {
+		return nil, nil
+	}
+	clone := *workout
+	clone.Entries = slices.Clone(workout.Entries)
+	return &clone, nil
+}
+
+func (f *fakeWorkoutStore) UpdateWorkout(workout *store.Workout) error {
+	for i := range workout.Entries {
+		workout.Entries[i].ID = 100 + i
+	}
+	return f.UpdateWorkoutDetails(workout)
+}
+
+func (f *fakeWorkoutStore) UpdateWorkoutDetails(workout *store.Workout) error {
+	for i, existing := range f.workouts {
+		if existing.ID == workout.ID && existing.UserID == workout.UserID {
+			if workout.Version != 0 && workout.Version != existing.Version {
+				return store.ErrEditConflict
+			}
+			workout.Version = existing.Version + 1
+			f.workouts[i] = workout
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (f *fakeWorkoutStore) DeleteWorkout(id int64, userID int, version int) error {
+	for i, existing := range f.workouts {
+		if int64(existing.ID) == id && existing.UserID == userID {
+			if version != 0 && version != existing.Version {
+				return store.ErrEditConflict
+			}
+			f.workouts = slices.Delete(f.workouts, i, i+1)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (f *fakeWorkoutStore) SearchWorkouts(userID int, query string, limit int) ([]*store.WorkoutSearchResult, error) {
+	var owned []*store.Workout
+	for _, workout := range f.workouts {
+		if workout.UserID == userID {
+			owned = append(owned, workout)
+		}
+	}
+	return store.SearchWorkoutsInMemory(owned, query, limit), nil
+}
+
+func TestHandleSearchWorkouts(t *testing.T) {
+	wh := NewWorkoutHandler(&fakeWorkoutStore{workouts: []*store.Workout{
+		{ID: 1, UserID: 1, Title: "Leg day"},
+		{ID: 2, UserID: 2, Title: "Leg day"},
+		{ID: 3, UserID: 1, Title: "Upper body"},
+	}}, log.New(io.Discard, "", 0))
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedIDs    []int
+	}{
+		{name: "matches own workouts only", query: "q=leg", expectedStatus: http.StatusOK, expectedIDs: []int{1}},
+		{name: "no matches", query: "q=deadlift", expectedStatus: http.StatusOK, expectedIDs: []int{}},
+		{name: "missing query", query: "q=+", expectedStatus: http.StatusBadRequest},
+		{name: "invalid limit", query: "q=leg&limit=500", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/workouts/search?"+tt.query, nil)
+			req = middleware.SetUser(req, &store.User{ID: 1})
+			rr := httptest.NewRecorder()
+			wh.HandleSearchWorkouts(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedIDs == nil {
+				return
+			}
+			var body struct {
+				Results []store.WorkoutSearchResult `json:"results"`
+			}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+			ids := []int{}
+			for _, result := range body.Results {
+				ids = append(ids, result.Workout.ID)
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+		})
+	}
+}
+
+func TestHandlePatchWorkout(t *testing.T) {
+	reps, seconds := 10, 60
+
+	tests := []struct {
+		name            string
+		contentType     string
+		body            string
+		expectedStatus  int
+		expectedWorkout *store.Workout
+	}{
+		{
+			name:           "updates only supplied fields",
+			contentType:    "application/merge-patch+json",
+			body:           `{"title":"Leg day (heavy)"}`,
+			expectedStatus: http.StatusOK,
+			expectedWorkout: &store.Workout{
+				ID: 1, UserID: 1, Title: "Leg day (heavy)", Description: "squats", DurationMinutes: 45, CaloriesBurned: 400, Version: 4,
+				Entries: []store.WorkoutEntry{{ID: 7, ExerciseName: "Squat", Sets: 5}},
+			},
+		},
+		{
+			name:           "null clears a nullable field",
+			body:           `{"calories_burned":null,"description":null}`,
+			expectedStatus: http.StatusOK,
+			expectedWorkout: &store.Workout{
+				ID: 1, UserID: 1, Title: "Leg day", DurationMinutes: 45, Version: 4,
+				Entries: []store.WorkoutEntry{{ID: 7, ExerciseName: "Squat", Sets: 5}},
+			},
+		},
+		{
+			name:           "entries are replaced as a whole",
+			contentType:    "application/json",
+			body:           `{"entries":[{"exercise_name":"Plank","sets":3,"reps":10,"duration_seconds":60}]}`,
+			expectedStatus: http.StatusOK,
+			expectedWorkout: &store.Workout{
+				ID: 1, UserID: 1, Title: "Leg day", Description: "squats", DurationMinutes: 45, CaloriesBurned: 400, Version: 4,
+				Entries: []store.WorkoutEntry{{ID: 100, ExerciseName: "Plank", Sets: 3, Reps: &reps, DurationSeconds: &seconds}},
+			},
+		},
+		{name: "null on a required field", body: `{"title":null}`, expectedStatus: http.StatusBadRequest},
+		{name: "invalid merged entry", body: `{"entries":[{"exercise_name":"Plank","sets":0}]}`, expectedStatus: http.StatusBadRequest},
+		{name: "read-only field", body: `{"user_id":2}`, expectedStatus: http.StatusBadRequest},
+		{name: "unknown field", body: `{"colour":"red"}`, expectedStatus: http.StatusBadRequest},
+		{name: "not an object", body: `["title"]`, expectedStatus: http.StatusBadRequest},
+		{name: "wrong type", body: `{"duration_minutes":"long"}`, expectedStatus: http.StatusBadRequest},
+		{name: "unsupported content type", contentType: "text/plain", body: `{}`, expectedStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeWorkoutStore{workouts: []*store.Workout{{
+				ID: 1, UserID: 1, Title: "Leg day", Description: "squats", DurationMinutes: 45, CaloriesBurned: 400, Version: 3,
+				Entries: []store.WorkoutEntry{{ID: 7, ExerciseName: "Squat", Sets: 5}},
+			}}}
+			wh := NewWorkoutHandler(fake, log.New(io.Discard, "", 0))
+
+			r := chi.NewRouter()
+			r.Patch("/workouts/{id}", wh.HandlePatchWorkout)
+			req := httptest.NewRequest(http.MethodPatch, "/workouts/1", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			req = middleware.SetUser(req, &store.User{ID: 1})
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code, rr.Body.String())
+			if tt.expectedWorkout != nil {
+				assert.Equal(t, tt.expectedWorkout, fake.workouts[0])
+			}
+		})
+	}
+}
+
+func TestHandlePatchWorkoutOtherUser(t *testing.T) {
+	fake := &fakeWorkoutStore{workouts: []*store.Workout{{ID: 1, UserID: 2, Title: "Leg day", DurationMinutes: 45}}}
+	wh := NewWorkoutHandler(fake, log.New(io.Discard, "", 0))
+
+	r := chi.NewRouter()
+	r.Patch("/workouts/{id}", wh.HandlePatchWorkout)
+	req := httptest.NewRequest(http.MethodPatch, "/workouts/1", strings.NewReader(`{"title":"mine now"}`))
+	req = middleware.SetUser(req, &store.User{ID: 1})
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, "Leg day", fake.workouts[0].Title)
+}
+
+func TestWorkoutConditionalRequests(t *testing.T) {
+	tests := []struct {
+		name            string
+		method          string
+		header          string
+		value           string
+		body            string
+		expectedStatus  int
+		expectedETag    string
+		expectedVersion int
+	}{
+		{name: "get sends etag", method: http.MethodGet, expectedStatus: http.StatusOK, expectedETag: `"1-3"`, expectedVersion: 3},
+		{name: "get not modified", method: http.MethodGet, header: "If-None-Match", value: `W/"1-3"`, expectedStatus: http.StatusNotModified, expectedETag: `"1-3"`, expectedVersion: 3},
+		{name: "get modified", method: http.MethodGet, header: "If-None-Match", value: `"1-2"`, expectedStatus: http.StatusOK, expectedETag: `"1-3"`, expectedVersion: 3},
+		{name: "put current version", method: http.MethodPut, header: "If-Match", value: `"1-3"`, body: `{"title":"Legs","duration_minutes":30}`, expectedStatus: http.StatusOK, expectedETag: `"1-4"`, expectedVersion: 4},
+		{name: "put stale version", method: http.MethodPut, header: "If-Match", value: `"1-2"`, body: `{"title":"Legs","duration_minutes":30}`, expectedStatus: http.StatusPreconditionFailed, expectedETag: `"1-3"`, expectedVersion: 3},
+		{name: "put weak etag", method: http.MethodPut, header: "If-Match", value: `W/"1-3"`, body: `{"title":"Legs","duration_minutes":30}`, expectedStatus: http.StatusPreconditionFailed, expectedVersion: 3},
+		{name: "put without precondition", method: http.MethodPut, body: `{"title":"Legs","duration_minutes":30,"version":1}`, expectedStatus: http.StatusOK, expectedVersion: 4},
+		{name: "patch current version", method: http.MethodPatch, header: "If-Match", value: `"1-0", "1-3"`, body: `{"title":"Legs"}`, expectedStatus: http.StatusOK, expectedETag: `"1-4"`, expectedVersion: 4},
+		{name: "patch stale version", method: http.MethodPatch, header: "If-Match", value: `"1-2"`, body: `{"title":"Legs"}`, expectedStatus: http.StatusPreconditionFailed, expectedETag: `"1-3"`, expectedVersion: 3},
+		{name: "patch version is read-only", method: http.MethodPatch, body: `{"version":9}`, expectedStatus: http.StatusBadRequest, expectedVersion: 3},
+		{name: "delete stale version", method: http.MethodDelete, header: "If-Match", value: `"1-2"`, expectedStatus: http.StatusPreconditionFailed, expectedVersion: 3},
+		{name: "delete any version", method: http.MethodDelete, header: "If-Match", value: "*", expectedStatus: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeWorkoutStore{workouts: []*store.Workout{{ID: 1, UserID: 1, Title: "Leg day", DurationMinutes: 45, Version: 3}}}
+			wh := NewWorkoutHandler(fake, log.New(io.Discard, "", 0))
+
+			r := chi.NewRouter()
+			r.Get("/workouts/{id}", wh.HandleGetWorkoutById)
+			r.Put("/workouts/{id}", wh.HandleUpdateWorkout)
+			r.Patch("/workouts/{id}", wh.HandlePatchWorkout)
+			r.Delete("/workouts/{id}", wh.HandleDeleteWorkout)
+
+			req := httptest.NewRequest(tt.method, "/workouts/1", strings.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			req = middleware.SetUser(req, &store.User{ID: 1})
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.expectedStatus, rr.Code, rr.Body.String())
+			if tt.expectedETag != "" {
+				assert.Equal(t, tt.expectedETag, rr.Header().Get("ETag"))
+			}
+			if tt.expectedVersion == 0 {
+				assert.Empty(t, fake.workouts)
+				return
+			}
+			assert.Equal(t, tt.expectedVersion, fake.workouts[0].Version)
+		})
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header   string
+		weak     bool
+		expected bool
+	}{
+		{header: `"1-3"`, expected: true},
+		{header: `"1-2", "1-3"`, expected: true},
+		{header: `*`, expected: true},
+		{header: `"1-2"`, expected: false},
+		{header: `W/"1-3"`, expected: false},
+		{header: `W/"1-3"`, weak: true, expected: true},
+		{header: ``, weak: true, expected: false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, etagMatches(tt.header, `"1-3"`, tt.weak), "header %q weak %v", tt.header, tt.weak)
+	}
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"log"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+)
+
+// grantAdmin gives the account registered under email the admin role, so a
+// fresh deployment has someone who can assign roles through the API. The
+// account must have verified its address; otherwise whoever registered the
+// address first would become admin. A missing or unverified account is only
+// logged, since the admin usually signs up after the first start.
+func grantAdmin(userStore store.UserStore, permissionStore store.PermissionStore, email string, logger *log.Logger) error {
+	user, err := userStore.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		logger.Printf("ADMIN_EMAIL: no account registered with %s yet; restart after signing up", email)
+		return nil
+	}
+	if !user.Activated {
+		logger.Printf("ADMIN_EMAIL: account %s has not been activated; restart after verifying the address", email)
+		return nil
+	}
+	err = permissionStore.AssignRole(user.ID, store.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	logger.Printf("ADMIN_EMAIL: granted the admin role to user %d", user.ID)
+	return nil
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/go-openapi/testify/v2/assert"
+)
+
+type fakeUserStore struct {
+	store.UserStore
+	users []*store.User
+	err   error
+}
+
+func (f *fakeUserStore) GetUserByEmail(email string) (*store.User, error) {
+	for _, u := range f.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, f.err
+		}
+	}
+	return nil, f.err
+}
+
+type fakePermissionStore struct {
+	store.PermissionStore
+	roles map[int][]string
+}
+
+func (f *fakePermissionStore) AssignRole(userID int, role string) error {
+	f.roles[userID] = append(f.roles[userID], role)
+	return nil
+}
+
+func TestGrantAdmin(t *testing.T) {
+	users := []*store.User{
+		{ID: 1, Email: "admin@example.com", Activated: true},
+		{ID: 2, Email: "pending@example.com"},
+	}
+
+	tests := []struct {
+		name          string
+		email         string
+		storeErr      error
+		expectedErr   bool
+		expectedRoles map[int][]string
+	}{
+		{name: "activated account", email: "Admin@Example.com", expectedRoles: map[int][]string{1: {store.RoleAdmin}}},
+		{name: "unactivated account", email: "pending@example.com", expectedRoles: map[int][]string{}},
+		{name: "no account yet", email: "nobody@example.com", expectedRoles: map[int][]string{}},
+		{name: "store error", email: "admin@example.com", storeErr: errors.New("db down"), expectedErr: true, expectedRoles: map[int][]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permissions := &fakePermissionStore{roles: map[int][]string{}}
+			err := grantAdmin(&fakeUserStore{users: users, err: tt.storeErr}, permissions, tt.email, log.New(io.Discard, "", 0))
+
+			if tt.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedRoles, permissions.roles)
+		})
+	}
+}
@@ -6,18 +6,36 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/alireza-akbarzadeh/fem_project/internal/api"
+	"github.com/alireza-akbarzadeh/fem_project/internal/blob"
+	"github.com/alireza-akbarzadeh/fem_project/internal/jobs"
+	"github.com/alireza-akbarzadeh/fem_project/internal/mailer"
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/ratelimit"
 	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/validation"
 	"github.com/alireza-akbarzadeh/fem_project/migrations"
 )
 
 type Application struct {
-	Logger         *log.Logger
-	WorkoutHandler *api.WorkoutHandler
-	UserHandler    *api.UserHandler
-	TokenHandler   *api.TokenHandler
-	DB             *sql.DB
+	Logger          *log.Logger
+	WorkoutHandler  *api.WorkoutHandler
+	UserHandler     *api.UserHandler
+	TokenHandler    *api.TokenHandler
+	PasswordHandler *api.PasswordHandler
+	RoleHandler     *api.RoleHandler
+	MFAHandler      *api.MFAHandler
+	APIKeyHandler   *api.APIKeyHandler
+	ExportHandler   *api.ExportHandler
+	AvatarHandler   *api.AvatarHandler
+	Middleware      middleware.UserMiddleware
+	Mailer          mailer.Mailer
+	RateLimiter     *ratelimit.Limiter
+	Jobs            *jobs.Runner
+	DB              *sql.DB
 }
 
 func NewApplication() (*Application, error) {
@@ -30,28 +48,173 @@ func NewApplication() (*Application, error) {
 		return nil, err
 	}
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	mail, err := newMailer()
+	if err != nil {
+		return nil, err
+	}
+	passwordPolicy, err := newPasswordPolicy()
+	if err != nil {
+		return nil, err
+	}
 
 	// out stores will go here
 	workoutStore := store.NewPostgresWorkoutStore(pgDb)
 	userStore := store.NewPostgresUserStore(pgDb)
 	tokenStore := store.NewPostgresTokenStore(pgDb)
+	permissionStore := store.NewPostgresPermissionStore(pgDb)
+	loginAttemptStore := store.NewPostgresLoginAttemptStore(pgDb)
+	totpStore := store.NewPostgresTOTPStore(pgDb)
+	apiKeyStore := store.NewPostgresAPIKeyStore(pgDb)
+
+	// ADMIN_EMAIL bootstraps the first admin; later ones are assigned through the API
+	if email := os.Getenv("ADMIN_EMAIL"); email != "" {
+		err = grantAdmin(userStore, permissionStore, email, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to grant admin role: %w", err)
+		}
+	}
 
 	// our handlers will go here
 	workoutHandler := api.NewWorkoutHandler(workoutStore, logger)
-	userHandler := api.NewUserHandler(userStore, logger)
-	tokenHandler := api.NewTokenHandler(tokenStore, userStore, logger)
+	userHandler := api.NewUserHandler(userStore, tokenStore, permissionStore, loginAttemptStore, mail, logger)
+	userHandler.PasswordPolicy = passwordPolicy
+	// ACCOUNT_DELETION_GRACE_PERIOD accepts durations like "168h"
+	if v := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD"); v != "" {
+		userHandler.DeletionGracePeriod, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCOUNT_DELETION_GRACE_PERIOD: %w", err)
+		}
+		if userHandler.DeletionGracePeriod <= 0 {
+			return nil, fmt.Errorf("invalid ACCOUNT_DELETION_GRACE_PERIOD: %q is not positive", v)
+		}
+	}
+	tokenHandler := api.NewTokenHandler(tokenStore, userStore, loginAttemptStore, totpStore, logger)
+	// set REQUIRE_ACTIVATION=false to let unverified accounts log in
+	tokenHandler.RequireActivation = os.Getenv("REQUIRE_ACTIVATION") != "false"
+	passwordHandler := api.NewPasswordHandler(userStore, tokenStore, mail, logger)
+	passwordHandler.Policy = passwordPolicy
+	roleHandler := api.NewRoleHandler(permissionStore, userStore, logger)
+	mfaHandler := api.NewMFAHandler(totpStore, loginAttemptStore, logger)
+	apiKeyHandler := api.NewAPIKeyHandler(apiKeyStore, logger)
+	exportHandler := api.NewExportHandler(workoutStore, tokenStore, apiKeyStore, logger)
+	blobStore := newBlobStore()
+	avatarHandler := api.NewAvatarHandler(userStore, blobStore, logger)
+	middlewareHandler := middleware.UserMiddleware{
+		UserStore:       userStore,
+		PermissionStore: permissionStore,
+		APIKeyStore:     apiKeyStore,
+	}
+
+	rateLimiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), map[string]ratelimit.Policy{
+		"default": {Requests: 120, Window: time.Minute},
+		// counted per address before authentication, so invalid tokens are
+		// limited too; high enough for several users behind one address
+		"ip": {Requests: 600, Window: time.Minute},
+		// the unauthenticated account endpoints are the usual abuse targets;
+		// each gets its own bucket so one cannot use up another's budget
+		"auth":           {Requests: 10, Window: time.Minute},
+		"signup":         {Requests: 10, Window: time.Minute},
+		"password-reset": {Requests: 5, Window: time.Minute},
+		// checked as the user types and on every token refresh, so looser
+		"availability": {Requests: 60, Window: time.Minute},
+		"refresh":      {Requests: 30, Window: time.Minute},
+	})
+
+	// background jobs; TOKEN_CLEANUP_INTERVAL accepts durations like "30m"
+	cleanupInterval := time.Hour
+	if v := os.Getenv("TOKEN_CLEANUP_INTERVAL"); v != "" {
+		cleanupInterval, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TOKEN_CLEANUP_INTERVAL: %w", err)
+		}
+		if cleanupInterval <= 0 {
+			return nil, fmt.Errorf("invalid TOKEN_CLEANUP_INTERVAL: %q is not positive", v)
+		}
+	}
+	jobRunner := jobs.NewRunner(logger)
+	jobRunner.Add("purge-expired-tokens", cleanupInterval, jobs.PurgeExpiredTokens(tokenStore, 1000, logger))
+	jobRunner.Add("purge-deleted-users", cleanupInterval, jobs.PurgeDeletedUsers(userStore, blobStore, api.AvatarKeys, 100, logger))
+	jobRunner.Start()
 
 	app := &Application{
-		Logger:         logger,
-		WorkoutHandler: workoutHandler,
-		UserHandler:    userHandler,
-		TokenHandler:   tokenHandler,
-		DB:             pgDb,
+		Logger:          logger,
+		WorkoutHandler:  workoutHandler,
+		UserHandler:     userHandler,
+		TokenHandler:    tokenHandler,
+		PasswordHandler: passwordHandler,
+		RoleHandler:     roleHandler,
+		MFAHandler:      mfaHandler,
+		APIKeyHandler:   apiKeyHandler,
+		ExportHandler:   exportHandler,
+		AvatarHandler:   avatarHandler,
+		Middleware:      middlewareHandler,
+		Mailer:          mail,
+		RateLimiter:     rateLimiter,
+		Jobs:            jobRunner,
+		DB:              pgDb,
 	}
 
 	return app, nil
 }
 
+// newMailer delivers through SMTP when SMTP_ADDR is set and otherwise writes
+// messages to MAILER_DIR (default tmp/mail) for local development.
+func newMailer() (mailer.Mailer, error) {
+	sender := os.Getenv("MAILER_SENDER")
+	if sender == "" {
+		sender = "Fem Project <no-reply@femproject.com>"
+	}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		smtpMailer, err := mailer.NewSMTPMailer(addr, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), sender)
+		if err != nil {
+			return nil, fmt.Errorf("MAILER_SENDER: %w", err)
+		}
+		return smtpMailer, nil
+	}
+	dir := os.Getenv("MAILER_DIR")
+	if dir == "" {
+		dir = "tmp/mail"
+	}
+	return mailer.NewFileMailer(dir, sender), nil
+}
+
+// newPasswordPolicy starts from the default policy and applies
+// PASSWORD_MIN_LENGTH, PASSWORD_HISTORY_SIZE and PASSWORD_BANNED_LIST, a file
+// with one banned password per line that is merged into the built-in list.
+func newPasswordPolicy() (*validation.PasswordPolicy, error) {
+	policy := validation.DefaultPasswordPolicy()
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid PASSWORD_MIN_LENGTH: %q", v)
+		}
+		policy.MinLength = n
+	}
+	if v := os.Getenv("PASSWORD_HISTORY_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid PASSWORD_HISTORY_SIZE: %q", v)
+		}
+		policy.HistorySize = n
+	}
+	if path := os.Getenv("PASSWORD_BANNED_LIST"); path != "" {
+		err := policy.LoadBannedFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load PASSWORD_BANNED_LIST: %w", err)
+		}
+	}
+	return policy, nil
+}
+
+// newBlobStore keeps uploaded files below BLOB_DIR (default tmp/blobs).
+func newBlobStore() blob.Store {
+	dir := os.Getenv("BLOB_DIR")
+	if dir == "" {
+		dir = "tmp/blobs"
+	}
+	return blob.NewDiskStore(dir)
+}
+
 func (app *Application) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "status is available\n")
 }
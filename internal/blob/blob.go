@@ -0,0 +1,21 @@
+// Package blob stores binary objects, such as uploaded images, behind a
+// small interface so the local disk store can be swapped for object storage.
+package blob
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps objects under slash-separated keys like "avatars/1/abc/64".
+type Store interface {
+	// Put stores the contents of r under key, replacing any existing object.
+	Put(key string, r io.Reader) error
+	// Get opens the object stored under key, or returns ErrNotFound.
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key. Deleting a missing
+	// object is not an error.
+	Delete(key string) error
+}
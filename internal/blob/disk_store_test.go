@@ -0,0 +1,42 @@
+package blob
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestDiskStore(t *testing.T) {
+	d := NewDiskStore(t.TempDir())
+
+	err := d.Put("avatars/1/abc/64", strings.NewReader("first"))
+	require.NoError(t, err)
+	err = d.Put("avatars/1/abc/64", strings.NewReader("second"))
+	require.NoError(t, err)
+
+	rc, err := d.Get("avatars/1/abc/64")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	require.NoError(t, d.Delete("avatars/1/abc/64"))
+	require.NoError(t, d.Delete("avatars/1/abc/64"), "deleting twice is fine")
+
+	_, err = d.Get("avatars/1/abc/64")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDiskStoreRejectsEscapingKeys(t *testing.T) {
+	d := NewDiskStore(t.TempDir())
+
+	for _, key := range []string{"", "/etc/passwd", "../outside", "a/../../outside", "a//b"} {
+		t.Run(key, func(t *testing.T) {
+			assert.Error(t, d.Put(key, strings.NewReader("x")))
+		})
+	}
+}
@@ -0,0 +1,218 @@
+// Package export builds the ZIP archive users download with a copy of their
+// account data.
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+)
+
+// Data is everything that goes into an export.
+type Data struct {
+	User        *store.User
+	Workouts    []*store.Workout
+	Sessions    []*store.Session
+	APIKeys     []*store.APIKey
+	GeneratedAt time.Time
+}
+
+// WriteZip writes d to w as a ZIP archive with a JSON and a CSV file for the
+// profile, the workouts and the session and API key metadata. Workouts are
+// flattened to one CSV row per entry.
+func WriteZip(w io.Writer, d *Data) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name  string
+		json  any
+		table [][]string
+	}{
+		{name: "profile", json: d.User, table: profileTable(d.User)},
+		{name: "workouts", json: d.Workouts, table: workoutsTable(d.Workouts)},
+		{name: "sessions", json: d.Sessions, table: sessionsTable(d.Sessions)},
+		{name: "api_keys", json: d.APIKeys, table: apiKeysTable(d.APIKeys)},
+	}
+	for _, file := range files {
+		err := writeJSON(zw, file.name+".json", file.json, d.GeneratedAt)
+		if err != nil {
+			return err
+		}
+		err = writeCSV(zw, file.name+".csv", file.table, d.GeneratedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func create(zw *zip.Writer, name string, modified time.Time) (io.Writer, error) {
+	return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+}
+
+func writeJSON(zw *zip.Writer, name string, v any, modified time.Time) error {
+	f, err := create(zw, name, modified)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	return enc.Encode(v)
+}
+
+func writeCSV(zw *zip.Writer, name string, rows [][]string, modified time.Time) error {
+	f, err := create(zw, name, modified)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	err = cw.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func profileTable(u *store.User) [][]string {
+	return [][]string{
+		{
+			"id", "username", "email", "bio", "display_name", "date_of_birth", "weight_unit", "distance_unit", "timezone", "height_cm",
+			"activated", "created_at", "updated_at", "deletion_scheduled_for",
+		},
+		{
+			strconv.Itoa(u.ID),
+			text(u.Username),
+			text(u.Email),
+			text(u.Bio),
+			text(u.DisplayName),
+			formatDate(u.DateOfBirth),
+			u.WeightUnit,
+			u.DistanceUnit,
+			u.Timezone,
+			formatFloat(u.HeightCM),
+			strconv.FormatBool(u.Activated),
+			formatTime(&u.CreatedAt),
+			formatTime(&u.UpdatedAt),
+			formatTime(u.DeletionScheduledFor),
+		},
+	}
+}
+
+func workoutsTable(workouts []*store.Workout) [][]string {
+	rows := [][]string{{
+		"workout_id", "title", "description", "duration_minutes", "calories_burned",
+		"entry_id", "exercise_name", "sets", "reps", "duration_seconds", "weight", "notes", "order_index",
+	}}
+	for _, wo := range workouts {
+		workout := []string{
+			strconv.Itoa(wo.ID),
+			text(wo.Title),
+			text(wo.Description),
+			strconv.Itoa(wo.DurationMinutes),
+			strconv.Itoa(wo.CaloriesBurned),
+		}
+		if len(wo.Entries) == 0 {
+			rows = append(rows, append(workout, "", "", "", "", "", "", "", ""))
+			continue
+		}
+		for _, e := range wo.Entries {
+			row := append([]string{}, workout...)
+			row = append(row,
+				strconv.Itoa(e.ID),
+				text(e.ExerciseName),
+				strconv.Itoa(e.Sets),
+				formatInt(e.Reps),
+				formatInt(e.DurationSeconds),
+				formatFloat(e.Weight),
+				text(formatString(e.Notes)),
+				strconv.Itoa(e.OrderIndex),
+			)
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func sessionsTable(sessions []*store.Session) [][]string {
+	rows := [][]string{{"id", "created_at", "last_used_at", "expires_at", "user_agent", "ip"}}
+	for _, s := range sessions {
+		rows = append(rows, []string{
+			strconv.FormatInt(s.ID, 10),
+			formatTime(&s.CreatedAt),
+			formatTime(s.LastUsedAt),
+			formatTime(&s.ExpiresAt),
+			text(s.UserAgent),
+			s.IP,
+		})
+	}
+	return rows
+}
+
+func apiKeysTable(keys []*store.APIKey) [][]string {
+	rows := [][]string{{"id", "name", "prefix", "scopes", "created_at", "last_used_at", "expires_at"}}
+	for _, k := range keys {
+		rows = append(rows, []string{
+			strconv.Itoa(k.ID),
+			text(k.Name),
+			k.Prefix,
+			strings.Join(k.Scopes, " "),
+			formatTime(&k.CreatedAt),
+			formatTime(k.LastUsedAt),
+			formatTime(k.ExpiresAt),
+		})
+	}
+	return rows
+}
+
+// text guards a user-supplied CSV value against formula injection:
+// spreadsheets evaluate cells starting with =, +, - or @ (and tab or
+// carriage return, which some treat the same way), so those get a leading
+// apostrophe that makes the cell literal text.
+func text(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatDate(d *store.Date) string {
+	if d == nil {
+		return ""
+	}
+	return d.String()
+}
+
+func formatInt(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
+
+func formatFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+func formatString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
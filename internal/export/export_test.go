@@ -0,0 +1,129 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestWriteZip(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	reps, weight, notes, height := 10, 42.5, "slow, controlled", 181.5
+	dob, err := store.ParseDate("1990-04-23")
+	require.NoError(t, err)
+	data := &Data{
+		User: &store.User{ID: 1, Username: "alice", Email: "alice@example.com", DateOfBirth: &dob, HeightCM: &height, CreatedAt: now, UpdatedAt: now},
+		Workouts: []*store.Workout{
+			{ID: 7, UserID: 1, Title: "Legs", DurationMinutes: 45, Entries: []store.WorkoutEntry{
+				{ID: 1, WorkoutID: 7, ExerciseName: "Squat", Sets: 3, Reps: &reps, Weight: &weight, Notes: &notes, OrderIndex: 1},
+				{ID: 2, WorkoutID: 7, ExerciseName: "Lunge", Sets: 2, OrderIndex: 2},
+			}},
+			{ID: 8, UserID: 1, Title: "Rest day"},
+		},
+		Sessions:    []*store.Session{{ID: 3, CreatedAt: now, ExpiresAt: now.Add(time.Hour), UserAgent: "curl", IP: "127.0.0.1"}},
+		APIKeys:     []*store.APIKey{},
+		GeneratedAt: now,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteZip(&buf, data))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		files[f.Name], err = io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	assert.ElementsMatch(t, []string{
+		"profile.json", "profile.csv",
+		"workouts.json", "workouts.csv",
+		"sessions.json", "sessions.csv",
+		"api_keys.json", "api_keys.csv",
+	}, names)
+
+	rows, err := csv.NewReader(bytes.NewReader(files["profile.csv"])).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "1990-04-23", rows[1][5])
+	assert.Equal(t, "181.5", rows[1][9])
+
+	var profile map[string]any
+	require.NoError(t, json.Unmarshal(files["profile.json"], &profile))
+	assert.Equal(t, "alice", profile["username"])
+	assert.NotContains(t, string(files["profile.json"]), "password")
+
+	rows, err = csv.NewReader(bytes.NewReader(files["workouts.csv"])).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+	assert.Equal(t, []string{"7", "Legs", "", "45", "0", "1", "Squat", "3", "10", "", "42.5", "slow, controlled", "1"}, rows[1])
+	assert.Equal(t, "Lunge", rows[2][6])
+	assert.Equal(t, []string{"8", "Rest day", "", "0", "0", "", "", "", "", "", "", "", ""}, rows[3])
+
+	rows, err = csv.NewReader(bytes.NewReader(files["api_keys.csv"])).ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, rows, 1)
+}
+
+func TestWriteZipEscapesFormulas(t *testing.T) {
+	notes := "@SUM(A1:A9)"
+	data := &Data{
+		User: &store.User{ID: 1, Username: "alice", Bio: "=HYPERLINK(\"http://evil.example\")"},
+		Workouts: []*store.Workout{
+			{ID: 7, Title: "+cmd|' /C calc'!A0", Description: "-2+3", Entries: []store.WorkoutEntry{
+				{ID: 1, ExerciseName: "\t=1+1", Notes: &notes, OrderIndex: 1},
+			}},
+		},
+		Sessions: []*store.Session{{ID: 3, UserAgent: "=curl"}},
+		APIKeys:  []*store.APIKey{{ID: 4, Name: "-deploy"}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteZip(&buf, data))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	readCSV := func(name string) [][]string {
+		f, err := zr.Open(name)
+		require.NoError(t, err)
+		defer f.Close()
+		rows, err := csv.NewReader(f).ReadAll()
+		require.NoError(t, err)
+		return rows
+	}
+
+	profile := readCSV("profile.csv")
+	assert.Equal(t, "alice", profile[1][1])
+	assert.Equal(t, "'=HYPERLINK(\"http://evil.example\")", profile[1][3])
+
+	workouts := readCSV("workouts.csv")
+	assert.Equal(t, "'+cmd|' /C calc'!A0", workouts[1][1])
+	assert.Equal(t, "'-2+3", workouts[1][2])
+	assert.Equal(t, "'\t=1+1", workouts[1][6])
+	assert.Equal(t, "'@SUM(A1:A9)", workouts[1][11])
+
+	assert.Equal(t, "'=curl", readCSV("sessions.csv")[1][4])
+	assert.Equal(t, "'-deploy", readCSV("api_keys.csv")[1][1])
+
+	f, err := zr.Open("workouts.json")
+	require.NoError(t, err)
+	defer f.Close()
+	raw, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `"title": "+cmd|' /C calc'!A0"`, "JSON keeps the original values")
+}
@@ -0,0 +1,57 @@
+// Package imaging resizes uploaded images using only the standard library.
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Thumbnail center-crops src to a square and scales it to size x size by
+// averaging the source pixels that fall into each target pixel.
+func Thumbnail(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	scale := float64(side) / float64(size)
+	for dy := 0; dy < size; dy++ {
+		sy0, sy1 := span(dy, scale, crop.Min.Y)
+		for dx := 0; dx < size; dx++ {
+			sx0, sx1 := span(dx, scale, crop.Min.X)
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(dx, dy, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// span returns the source range covered by target pixel i, always at least
+// one pixel wide so upscaling repeats pixels.
+func span(i int, scale float64, offset int) (int, int) {
+	start := int(float64(i) * scale)
+	end := max(int(float64(i+1)*scale), start+1)
+	return offset + start, offset + end
+}
+
+// Flatten draws img over a solid background, for formats such as JPEG that
+// cannot store transparency.
+func Flatten(img image.Image, bg color.Color) *image.RGBA {
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
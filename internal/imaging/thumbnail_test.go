@@ -0,0 +1,48 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/assert"
+)
+
+func TestThumbnail(t *testing.T) {
+	// 40x20 image: left half red, right half blue, with a green 10px border
+	// on the left and right that the square crop should drop
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			switch {
+			case x < 10 || x >= 30:
+				src.Set(x, y, color.RGBA{G: 255, A: 255})
+			case x < 20:
+				src.Set(x, y, color.RGBA{R: 255, A: 255})
+			default:
+				src.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	thumb := Thumbnail(src, 4)
+	assert.Equal(t, image.Rect(0, 0, 4, 4), thumb.Bounds())
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, thumb.RGBAAt(0, 0))
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, thumb.RGBAAt(1, 3))
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, thumb.RGBAAt(2, 0))
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, thumb.RGBAAt(3, 3))
+
+	up := Thumbnail(src, 40)
+	assert.Equal(t, image.Rect(0, 0, 40, 40), up.Bounds())
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, up.RGBAAt(0, 0))
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, up.RGBAAt(39, 39))
+}
+
+func TestFlatten(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{R: 255, A: 255})
+
+	flat := Flatten(src, color.White)
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, flat.RGBAAt(0, 0))
+	assert.Equal(t, color.RGBA{R: 255, G: 255, B: 255, A: 255}, flat.RGBAAt(1, 0))
+}
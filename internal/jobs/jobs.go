@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+// Runner runs registered jobs on their own interval in the background until
+// Stop is called.
+type Runner struct {
+	logger *log.Logger
+	jobs   []job
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+}
+
+func NewRunner(logger *log.Logger) *Runner {
+	return &Runner{logger: logger}
+}
+
+// Add registers a job. It must be called before Start.
+func (r *Runner) Add(name string, interval time.Duration, run func(ctx context.Context) error) {
+	r.jobs = append(r.jobs, job{name: name, interval: interval, run: run})
+}
+
+// Start runs every job once immediately and then once per interval.
+func (r *Runner) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	for _, j := range r.jobs {
+		r.wg.Add(1)
+		go r.loop(ctx, j)
+	}
+}
+
+// Stop cancels running jobs and waits for them to return.
+func (r *Runner) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	r.wg.Wait()
+}
+
+func (r *Runner) loop(ctx context.Context, j job) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		err := j.run(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Printf("job %s failed: %v", j.name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package jobs
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/blob"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/go-openapi/testify/v2/assert"
+)
+
+type fakeTokenStore struct {
+	store.TokenStore
+	expired int64
+	calls   int
+}
+
+func (f *fakeTokenStore) DeleteExpiredTokens(limit int) (int64, error) {
+	f.calls++
+	deleted := min(f.expired, int64(limit))
+	f.expired -= deleted
+	return deleted, nil
+}
+
+func TestPurgeExpiredTokens(t *testing.T) {
+	fake := &fakeTokenStore{expired: 25}
+	before := tokensPurged.Value()
+
+	err := PurgeExpiredTokens(fake, 10, log.New(io.Discard, "", 0))(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), fake.expired)
+	assert.Equal(t, 3, fake.calls, "keeps deleting batches until one comes back short")
+	assert.Equal(t, before+25, tokensPurged.Value())
+}
+
+type fakeUserStore struct {
+	store.UserStore
+	avatars []string
+	calls   int
+}
+
+func (f *fakeUserStore) DeleteScheduledUsers(limit int) (int64, []string, error) {
+	f.calls++
+	n := min(len(f.avatars), limit)
+	var avatars []string
+	for _, key := range f.avatars[:n] {
+		if key != "" {
+			avatars = append(avatars, key)
+		}
+	}
+	f.avatars = f.avatars[n:]
+	return int64(n), avatars, nil
+}
+
+type fakeBlobStore struct {
+	blob.Store
+	deleted []string
+}
+
+func (f *fakeBlobStore) Delete(key string) error {
+	f.deleted = append(f.deleted, key)
+	return nil
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	fake := &fakeUserStore{avatars: []string{"avatars/1/a", "", "avatars/3/c"}}
+	blobs := &fakeBlobStore{}
+	avatarKeys := func(prefix string) []string {
+		return []string{prefix + "/original", prefix + "/64"}
+	}
+	before := usersPurged.Value()
+
+	err := PurgeDeletedUsers(fake, blobs, avatarKeys, 2, log.New(io.Discard, "", 0))(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fake.calls)
+	assert.Equal(t, before+3, usersPurged.Value())
+	assert.Equal(t, []string{
+		"avatars/1/a/original", "avatars/1/a/64",
+		"avatars/3/c/original", "avatars/3/c/64",
+	}, blobs.deleted, "every size of every purged avatar is deleted")
+}
+
+func TestRunnerStop(t *testing.T) {
+	var runs atomic.Int32
+	r := NewRunner(log.New(io.Discard, "", 0))
+	r.Add("count", time.Millisecond, func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	})
+
+	r.Start()
+	assert.Eventually(t, func() bool { return runs.Load() >= 3 }, time.Second, time.Millisecond)
+	r.Stop()
+
+	stopped := runs.Load()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, stopped, runs.Load(), "no runs after Stop returns")
+}
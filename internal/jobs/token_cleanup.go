@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"context"
+	"expvar"
+	"log"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+)
+
+var (
+	tokensPurged     = expvar.NewInt("tokens_purged_total")
+	tokenCleanupRuns = expvar.NewInt("token_cleanup_runs_total")
+)
+
+// PurgeExpiredTokens returns a job that deletes expired rows from the token
+// table in batches of batchSize until none are left.
+func PurgeExpiredTokens(tokenStore store.TokenStore, batchSize int, logger *log.Logger) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		tokenCleanupRuns.Add(1)
+		var total int64
+		for ctx.Err() == nil {
+			deleted, err := tokenStore.DeleteExpiredTokens(batchSize)
+			if err != nil {
+				return err
+			}
+			total += deleted
+			tokensPurged.Add(deleted)
+			if deleted < int64(batchSize) {
+				break
+			}
+		}
+		if total > 0 {
+			logger.Printf("purged %d expired tokens", total)
+		}
+		return nil
+	}
+}
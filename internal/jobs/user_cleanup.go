@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"context"
+	"expvar"
+	"log"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/blob"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+)
+
+var (
+	usersPurged     = expvar.NewInt("users_purged_total")
+	userCleanupRuns = expvar.NewInt("user_cleanup_runs_total")
+)
+
+// PurgeDeletedUsers returns a job that permanently deletes accounts whose
+// deletion grace period has ended, in batches of batchSize, along with every
+// blob avatarKeys lists for their avatars.
+func PurgeDeletedUsers(userStore store.UserStore, blobs blob.Store, avatarKeys func(prefix string) []string, batchSize int, logger *log.Logger) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		userCleanupRuns.Add(1)
+		var total int64
+		for ctx.Err() == nil {
+			deleted, avatars, err := userStore.DeleteScheduledUsers(batchSize)
+			if err != nil {
+				return err
+			}
+			total += deleted
+			usersPurged.Add(deleted)
+			// the rows are gone, so a blob that fails to delete is only logged
+			for _, prefix := range avatars {
+				for _, key := range avatarKeys(prefix) {
+					err = blobs.Delete(key)
+					if err != nil {
+						logger.Printf("failed to delete avatar blob %s: %v", key, err)
+					}
+				}
+			}
+			if deleted < int64(batchSize) {
+				break
+			}
+		}
+		if total > 0 {
+			logger.Printf("deleted %d accounts scheduled for deletion", total)
+		}
+		return nil
+	}
+}
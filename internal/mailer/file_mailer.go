@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileMailer writes every message as an .eml file in a directory instead of
+// delivering it, which is enough for local development and tests.
+type FileMailer struct {
+	dir    string
+	sender string
+}
+
+func NewFileMailer(dir, sender string) *FileMailer {
+	return &FileMailer{
+		dir:    dir,
+		sender: sender,
+	}
+}
+
+func (m *FileMailer) Send(recipient, templateFile string, data any) error {
+	msg, err := Render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+	raw, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(m.dir, 0o755)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s-%s.eml",
+		time.Now().UnixNano(),
+		strings.TrimSuffix(templateFile, filepath.Ext(templateFile)),
+		strings.NewReplacer("@", "_at_", "/", "_").Replace(recipient),
+	)
+	return os.WriteFile(filepath.Join(m.dir, name), raw, 0o644)
+}
@@ -0,0 +1,103 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"mime/quotedprintable"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Mailer sends templated email. Each template file under templates/ defines a
+// "subject", a "plainBody" and an "htmlBody" block.
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+type Message struct {
+	From     string
+	To       string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+func Render(sender, recipient, templateFile string, data any) (*Message, error) {
+	textTmpl, err := texttemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+	subject := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+	plainBody := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		From:     sender,
+		To:       recipient,
+		Subject:  strings.TrimSpace(subject.String()),
+		TextBody: plainBody.String(),
+		HTMLBody: htmlBody.String(),
+	}, nil
+}
+
+// Bytes encodes the message as a multipart/alternative MIME document.
+func (m *Message) Bytes() ([]byte, error) {
+	boundary := fmt.Sprintf("fem-%d", time.Now().UnixNano())
+	buf := new(bytes.Buffer)
+
+	fmt.Fprintf(buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(buf, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain", m.TextBody},
+		{"text/html", m.HTMLBody},
+	}
+	for _, part := range parts {
+		fmt.Fprintf(buf, "--%s\r\n", boundary)
+		fmt.Fprintf(buf, "Content-Type: %s; charset=UTF-8\r\n", part.contentType)
+		fmt.Fprintf(buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		qp := quotedprintable.NewWriter(buf)
+		_, err := qp.Write([]byte(part.body))
+		if err != nil {
+			return nil, err
+		}
+		err = qp.Close()
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(buf, "\r\n")
+	}
+	fmt.Fprintf(buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes(), nil
+}
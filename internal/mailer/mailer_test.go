@@ -0,0 +1,127 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+var resetData = map[string]any{
+	"Username": "alice",
+	"Token":    "ABC123",
+	"Expiry":   "soon",
+}
+
+func TestRender(t *testing.T) {
+	msg, err := Render("from@example.com", "alice@example.com", "password_reset.tmpl", resetData)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Reset your Fem Project password", msg.Subject)
+	assert.Contains(t, msg.TextBody, "Hi alice,")
+	assert.Contains(t, msg.TextBody, `"token": "ABC123"`)
+	assert.Contains(t, msg.HTMLBody, "&lt;your new password&gt;")
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := NewFileMailer(dir, "from@example.com")
+
+	err := m.Send("alice@example.com", "password_reset.tmpl", resetData)
+	require.NoError(t, err)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.True(t, strings.HasSuffix(files[0].Name(), "-password_reset-alice_at_example.com.eml"))
+
+	raw, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "To: alice@example.com\r\n")
+	assert.Contains(t, string(raw), "Content-Type: text/html; charset=UTF-8")
+}
+
+func TestSMTPMailer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go fakeSMTPServer(t, ln, "from@example.com", received)
+
+	m, err := NewSMTPMailer(ln.Addr().String(), "", "", "Fem Project <from@example.com>")
+	require.NoError(t, err)
+	err = m.Send("alice@example.com", "password_reset.tmpl", resetData)
+	require.NoError(t, err)
+
+	data := <-received
+	assert.Contains(t, data, "From: Fem Project <from@example.com>\r\n")
+	assert.Contains(t, data, "Subject: Reset your Fem Project password")
+}
+
+func TestNewSMTPMailerRejectsInvalidSender(t *testing.T) {
+	_, err := NewSMTPMailer("localhost:25", "", "", "Fem Project")
+	assert.Error(t, err)
+}
+
+// fakeSMTPServer accepts a single connection and speaks just enough SMTP for
+// net/smtp.SendMail, sending the DATA payload to received. Like a real
+// server it rejects a MAIL FROM whose path is not <envelopeSender>.
+func fakeSMTPServer(t *testing.T, ln net.Listener, envelopeSender string, received chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Errorf("accept: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost fake smtp")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL"):
+			if strings.TrimSpace(line) != "MAIL FROM:<"+envelopeSender+">" {
+				t.Errorf("unexpected envelope sender: %q", strings.TrimSpace(line))
+				reply("553 invalid sender address")
+				continue
+			}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			received <- data.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+)
+
+type SMTPMailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+	// envelopeSender is the bare address of sender, used for MAIL FROM
+	envelopeSender string
+}
+
+// NewSMTPMailer delivers mail through the SMTP server at addr. Username and
+// password may be empty for servers that do not require authentication.
+// sender may include a display name, as in "Fem Project <no-reply@example.com>".
+func NewSMTPMailer(addr, username, password, sender string) (*SMTPMailer, error) {
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", sender, err)
+	}
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		addr:           addr,
+		auth:           auth,
+		sender:         sender,
+		envelopeSender: from.Address,
+	}, nil
+}
+
+func (m *SMTPMailer) Send(recipient, templateFile string, data any) error {
+	msg, err := Render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+	raw, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.envelopeSender, []string{recipient}, raw)
+}
@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
+	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type UserMiddleware struct {
+	UserStore       store.UserStore
+	PermissionStore store.PermissionStore
+	APIKeyStore     store.APIKeyStore
+}
+
+type contextKey string
+
+const (
+	UserContextKey         = contextKey("user")
+	TokenContextKey        = contextKey("token")
+	APIKeyScopesContextKey = contextKey("api_key_scopes")
+)
+
+func SetUser(r *http.Request, user *store.User) *http.Request {
+	ctx := context.WithValue(r.Context(), UserContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// GetToken returns the plaintext bearer token the request was authenticated
+// with, or an empty string for anonymous requests.
+func GetToken(r *http.Request) string {
+	token, _ := r.Context().Value(TokenContextKey).(string)
+	return token
+}
+
+// GetAPIKeyScopes returns the scopes of the API key the request was
+// authenticated with, or nil when it used a session token.
+func GetAPIKeyScopes(r *http.Request) []string {
+	scopes, _ := r.Context().Value(APIKeyScopesContextKey).([]string)
+	return scopes
+}
+
+func GetUser(r *http.Request) *store.User {
+	user, ok := r.Context().Value(UserContextKey).(*store.User)
+	if !ok {
+		panic("missing user in request")
+	}
+	return user
+}
+
+// Authenticate resolves the bearer token on the request into a user. Requests
+// without an Authorization header continue as store.AnonymousUser so that
+// RequireUser can decide per route whether a login is needed.
+func (um *UserMiddleware) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			r = SetUser(r, store.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		headerParts := strings.Split(authHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid authorization header"})
+			return
+		}
+
+		token := headerParts[1]
+		if tokens.IsAPIKey(token) {
+			um.authenticateAPIKey(w, r, next, token)
+			return
+		}
+		user, err := um.UserStore.GetUserToken(tokens.ScopeAuth, token)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		if user == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired token"})
+			return
+		}
+
+		r = SetUser(r, user)
+		r = r.WithContext(context.WithValue(r.Context(), TokenContextKey, token))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (um *UserMiddleware) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	user, scopes, err := um.APIKeyStore.GetUserForAPIKey(key)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if user == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired api key"})
+		return
+	}
+	r = SetUser(r, user)
+	r = r.WithContext(context.WithValue(r.Context(), APIKeyScopesContextKey, scopes))
+	next.ServeHTTP(w, r)
+}
+
+// RequireUser only lets through requests made with a session token. API keys
+// are rejected unless the route opts in with RequireScope.
+func (um *UserMiddleware) RequireUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetAPIKeyScopes(r) != nil {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "this route cannot be accessed with an api key"})
+			return
+		}
+		um.requireAuthenticated(next).ServeHTTP(w, r)
+	})
+}
+
+// RequireScope lets through session tokens and API keys that were granted
+// the given scope.
+func (um *UserMiddleware) RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return um.requireAuthenticated(func(w http.ResponseWriter, r *http.Request) {
+		scopes := GetAPIKeyScopes(r)
+		if scopes != nil && !slices.Contains(scopes, scope) {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "api key is missing the " + scope + " scope"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (um *UserMiddleware) requireAuthenticated(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r)
+		if user.IsAnonymous() {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "you must be logged in to access this route"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission only lets through authenticated users whose roles grant
+// the given permission code.
+func (um *UserMiddleware) RequirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	return um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		allowed, err := um.hasPermission(GetUser(r), code)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		if !allowed {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "you do not have permission to access this resource"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireSelfOrPermission lets users act on their own account, identified by
+// the {id} URL parameter, and otherwise falls back to RequirePermission.
+func (um *UserMiddleware) RequireSelfOrPermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	withPermission := um.RequirePermission(code, next)
+	return um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err == nil && id == GetUser(r).ID {
+			next.ServeHTTP(w, r)
+			return
+		}
+		withPermission.ServeHTTP(w, r)
+	})
+}
+
+func (um *UserMiddleware) hasPermission(user *store.User, code string) (bool, error) {
+	permissions, err := um.PermissionStore.GetAllForUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Include(code), nil
+}
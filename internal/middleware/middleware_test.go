@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-openapi/testify/v2/assert"
+)
+
+type fakeUserStore struct {
+	store.UserStore
+	tokens map[string]*store.User
+}
+
+func (f *fakeUserStore) GetUserToken(scope, tokenPlainText string) (*store.User, error) {
+	return f.tokens[scope+":"+tokenPlainText], nil
+}
+
+type fakePermissionStore struct {
+	store.PermissionStore
+	permissions map[int]store.Permissions
+}
+
+func (f *fakePermissionStore) GetAllForUser(userID int) (store.Permissions, error) {
+	return f.permissions[userID], nil
+}
+
+type fakeAPIKeyStore struct {
+	store.APIKeyStore
+	user   *store.User
+	key    string
+	scopes []string
+}
+
+func (f *fakeAPIKeyStore) GetUserForAPIKey(plaintext string) (*store.User, []string, error) {
+	if plaintext != f.key {
+		return nil, nil, nil
+	}
+	return f.user, f.scopes, nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	alice := &store.User{ID: 1, Username: "alice"}
+	um := &UserMiddleware{UserStore: &fakeUserStore{
+		tokens: map[string]*store.User{"authentication:good": alice},
+	}}
+
+	tests := []struct {
+		name           string
+		header         string
+		expectedStatus int
+		expectedUser   string
+	}{
+		{name: "no header is anonymous", header: "", expectedStatus: http.StatusUnauthorized},
+		{name: "malformed header", header: "Token good", expectedStatus: http.StatusUnauthorized},
+		{name: "unknown token", header: "Bearer bad", expectedStatus: http.StatusUnauthorized},
+		{name: "valid token", header: "Bearer good", expectedStatus: http.StatusOK, expectedUser: "alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen *store.User
+			handler := um.Authenticate(um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+				seen = GetUser(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedUser != "" {
+				assert.Equal(t, tt.expectedUser, seen.Username)
+			} else {
+				assert.Nil(t, seen)
+				assert.Equal(t, "Bearer", rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestRequireSelfOrPermission(t *testing.T) {
+	alice := &store.User{ID: 1, Username: "alice"}
+	admin := &store.User{ID: 2, Username: "admin"}
+	um := &UserMiddleware{
+		UserStore: &fakeUserStore{tokens: map[string]*store.User{
+			"authentication:alice": alice,
+			"authentication:admin": admin,
+		}},
+		PermissionStore: &fakePermissionStore{permissions: map[int]store.Permissions{
+			admin.ID: {"users:delete"},
+		}},
+	}
+
+	r := chi.NewRouter()
+	r.Use(um.Authenticate)
+	r.Delete("/users/{id}", um.RequireSelfOrPermission("users:delete", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name           string
+		token          string
+		path           string
+		expectedStatus int
+	}{
+		{name: "own account", token: "alice", path: "/users/1", expectedStatus: http.StatusNoContent},
+		{name: "someone else without permission", token: "alice", path: "/users/2", expectedStatus: http.StatusForbidden},
+		{name: "someone else with permission", token: "admin", path: "/users/1", expectedStatus: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	alice := &store.User{ID: 1, Username: "alice"}
+	um := &UserMiddleware{
+		UserStore: &fakeUserStore{tokens: map[string]*store.User{"authentication:session": alice}},
+		APIKeyStore: &fakeAPIKeyStore{
+			user:   alice,
+			key:    "fem_readonly",
+			scopes: []string{"workouts:read"},
+		},
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	tests := []struct {
+		name           string
+		token          string
+		handler        http.HandlerFunc
+		expectedStatus int
+	}{
+		{name: "session on scoped route", token: "session", handler: um.RequireScope("workouts:write", ok), expectedStatus: http.StatusOK},
+		{name: "key with scope", token: "fem_readonly", handler: um.RequireScope("workouts:read", ok), expectedStatus: http.StatusOK},
+		{name: "key without scope", token: "fem_readonly", handler: um.RequireScope("workouts:write", ok), expectedStatus: http.StatusForbidden},
+		{name: "key on session-only route", token: "fem_readonly", handler: um.RequireUser(ok), expectedStatus: http.StatusForbidden},
+		{name: "unknown key", token: "fem_unknown", handler: um.RequireScope("workouts:read", ok), expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rr := httptest.NewRecorder()
+			um.Authenticate(tt.handler).ServeHTTP(rr, req)
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+		})
+	}
+}
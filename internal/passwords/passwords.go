@@ -0,0 +1,126 @@
+package passwords
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hashes are stored in PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>. Hashes written before
+// Argon2id was introduced are plain bcrypt ($2a$...) and still verify.
+const argon2idPrefix = "$argon2id$"
+
+var ErrUnknownFormat = errors.New("passwords: unknown hash format")
+
+type Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams are used for new hashes. Raising them makes existing hashes
+// report NeedsRehash so they are upgraded on the next login.
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var b64 = base64.RawStdEncoding
+
+func Hash(plaintext string) ([]byte, error) {
+	return HashWithParams(plaintext, DefaultParams)
+}
+
+func HashWithParams(plaintext string, p Params) ([]byte, error) {
+	salt := make([]byte, p.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(plaintext), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		b64.EncodeToString(salt), b64.EncodeToString(key))
+	return []byte(encoded), nil
+}
+
+func Verify(plaintext string, encoded []byte) (bool, error) {
+	if isBcrypt(encoded) {
+		err := bcrypt.CompareHashAndPassword(encoded, []byte(plaintext))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(plaintext), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// NeedsRehash reports whether encoded was produced by an older algorithm or
+// with parameters other than DefaultParams.
+func NeedsRehash(encoded []byte) bool {
+	if isBcrypt(encoded) {
+		return true
+	}
+	p, _, _, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p != DefaultParams
+}
+
+func isBcrypt(encoded []byte) bool {
+	return bytes.HasPrefix(encoded, []byte("$2a$")) ||
+		bytes.HasPrefix(encoded, []byte("$2b$")) ||
+		bytes.HasPrefix(encoded, []byte("$2y$"))
+}
+
+func decodeArgon2id(encoded []byte) (Params, []byte, []byte, error) {
+	var p Params
+	if !bytes.HasPrefix(encoded, []byte(argon2idPrefix)) {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	parts := bytes.Split(encoded, []byte("$"))
+	if len(parts) != 6 {
+		return p, nil, nil, ErrUnknownFormat
+	}
+
+	var version int
+	_, err := fmt.Sscanf(string(parts[2]), "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	_, err = fmt.Sscanf(string(parts[3]), "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
+	if err != nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+
+	salt, err := b64.DecodeString(string(parts[4]))
+	if err != nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	key, err := b64.DecodeString(string(parts[5]))
+	if err != nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,57 @@
+package passwords
+
+import (
+	"testing"
+
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	hash, err := Hash("Passw0rd!")
+	require.NoError(t, err)
+	assert.Regexp(t, `^\$argon2id\$v=19\$m=65536,t=3,p=2\$`, string(hash))
+
+	ok, err := Verify("Passw0rd!", hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = Verify("wrong", hash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.False(t, NeedsRehash(hash))
+}
+
+func TestLegacyBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Passw0rd!"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	ok, err := Verify("Passw0rd!", hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = Verify("wrong", hash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.True(t, NeedsRehash(hash))
+}
+
+func TestNeedsRehashOnOutdatedParams(t *testing.T) {
+	weaker := DefaultParams
+	weaker.Iterations = 1
+	hash, err := HashWithParams("Passw0rd!", weaker)
+	require.NoError(t, err)
+
+	ok, err := Verify("Passw0rd!", hash)
+	require.NoError(t, err)
+	assert.True(t, ok, "old parameters still verify")
+	assert.True(t, NeedsRehash(hash))
+}
+
+func TestVerifyUnknownFormat(t *testing.T) {
+	_, err := Verify("Passw0rd!", []byte("plaintext"))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
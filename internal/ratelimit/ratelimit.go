@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/alireza-akbarzadeh/fem_project/internal/utils"
+)
+
+// Policy describes a token bucket that holds Requests tokens and refills
+// completely over Window.
+type Policy struct {
+	Requests int
+	Window   time.Duration
+}
+
+func (p Policy) refillRate() float64 {
+	return float64(p.Requests) / p.Window.Seconds()
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps bucket state. MemoryStore is enough for a single instance; a
+// shared backend can be plugged in for multiple replicas.
+type Store interface {
+	Take(key string, policy Policy, now time.Time) (Result, error)
+}
+
+type Limiter struct {
+	store    Store
+	policies map[string]Policy
+}
+
+func NewLimiter(store Store, policies map[string]Policy) *Limiter {
+	return &Limiter{
+		store:    store,
+		policies: policies,
+	}
+}
+
+// Limit returns middleware enforcing the named policy. Buckets are kept per
+// policy and per client, where the client is the authenticated user when
+// there is one and the remote IP otherwise.
+func (l *Limiter) Limit(name string) func(http.Handler) http.Handler {
+	return l.limit(name, clientKey)
+}
+
+// LimitByIP returns middleware enforcing the named policy per remote IP. It
+// is meant to run before authentication, so that requests without
+// credentials or with invalid ones are limited before they reach the token
+// lookup.
+func (l *Limiter) LimitByIP(name string) func(http.Handler) http.Handler {
+	return l.limit(name, ipKey)
+}
+
+func (l *Limiter) limit(name string, key func(*http.Request) string) func(http.Handler) http.Handler {
+	policy, ok := l.policies[name]
+	if !ok {
+		panic(fmt.Sprintf("ratelimit: unknown policy %q", name))
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, err := l.store.Take(name+":"+key(r), policy, time.Now())
+			if err != nil {
+				utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+				return
+			}
+
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Requests, int(policy.Window.Seconds())))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				utils.WriteJSON(w, http.StatusTooManyRequests, utils.Envelope{"error": "rate limit exceeded"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func clientKey(r *http.Request) string {
+	user, ok := r.Context().Value(middleware.UserContextKey).(*store.User)
+	if ok && !user.IsAnonymous() {
+		return "user:" + strconv.Itoa(user.ID)
+	}
+	return ipKey(r)
+}
+
+func ipKey(r *http.Request) string {
+	return "ip:" + utils.ClientIP(r)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alireza-akbarzadeh/fem_project/internal/middleware"
+	"github.com/alireza-akbarzadeh/fem_project/internal/store"
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	s := NewMemoryStore()
+	policy := Policy{Requests: 2, Window: 2 * time.Second}
+	now := time.Now()
+
+	first, err := s.Take("k", policy, now)
+	require.NoError(t, err)
+	assert.True(t, first.Allowed)
+	assert.Equal(t, 1, first.Remaining)
+
+	second, _ := s.Take("k", policy, now)
+	assert.True(t, second.Allowed)
+	assert.Equal(t, 0, second.Remaining)
+
+	third, _ := s.Take("k", policy, now)
+	assert.False(t, third.Allowed)
+	assert.Equal(t, time.Second, third.RetryAfter)
+	assert.Equal(t, 2*time.Second, third.Reset)
+
+	other, _ := s.Take("other", policy, now)
+	assert.True(t, other.Allowed, "buckets are independent per key")
+
+	refilled, _ := s.Take("k", policy, now.Add(time.Second))
+	assert.True(t, refilled.Allowed)
+}
+
+func TestLimiterMiddleware(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), map[string]Policy{
+		"auth": {Requests: 1, Window: time.Minute},
+	})
+	handler := l.Limit("auth")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/tokens", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "1;w=60", rr.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+
+	rr = send("10.0.0.1:5678")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+
+	rr = send("10.0.0.2:1234")
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestLimitByIPIgnoresUser(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), map[string]Policy{
+		"ip": {Requests: 1, Window: time.Minute},
+	})
+	handler := l.LimitByIP("ip")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(userID int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/workouts", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req = middleware.SetUser(req, &store.User{ID: userID})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, send(1).Code)
+	assert.Equal(t, http.StatusTooManyRequests, send(2).Code, "requests from one address share a bucket")
+}
+
+func TestPoliciesHaveSeparateBuckets(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), map[string]Policy{
+		"auth":         {Requests: 1, Window: time.Minute},
+		"availability": {Requests: 2, Window: time.Minute},
+		"refresh":      {Requests: 1, Window: time.Minute},
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handlers := map[string]http.Handler{}
+	for _, name := range []string{"auth", "availability", "refresh"} {
+		handlers[name] = l.Limit(name)(ok)
+	}
+
+	send := func(policy string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rr := httptest.NewRecorder()
+		handlers[policy].ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("availability"))
+	assert.Equal(t, http.StatusOK, send("availability"))
+	assert.Equal(t, http.StatusTooManyRequests, send("availability"))
+	assert.Equal(t, http.StatusOK, send("refresh"), "availability checks leave the refresh budget alone")
+	assert.Equal(t, http.StatusTooManyRequests, send("refresh"))
+	assert.Equal(t, http.StatusOK, send("auth"), "neither touches the login budget")
+}
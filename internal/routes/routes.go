@@ -1,8 +1,11 @@
 package routes
 
 import (
+	"expvar"
+
 	_ "github.com/alireza-akbarzadeh/fem_project/docs"
 	"github.com/alireza-akbarzadeh/fem_project/internal/app"
+	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
 	"github.com/go-chi/chi/v5"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
@@ -16,20 +19,70 @@ func SetupRoute(app *app.Application) *chi.Mux {
 
 	// API v1 routes
 	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(app.RateLimiter.LimitByIP("ip"))
+		r.Use(app.Middleware.Authenticate)
+		r.Use(app.RateLimiter.Limit("default"))
+		login := r.With(app.RateLimiter.Limit("auth"))
+		signup := r.With(app.RateLimiter.Limit("signup"))
+		availability := r.With(app.RateLimiter.Limit("availability"))
+		refresh := r.With(app.RateLimiter.Limit("refresh"))
+		passwordReset := r.With(app.RateLimiter.Limit("password-reset"))
+
 		// workout
-		r.Get("/workouts/{id}", app.WorkoutHandler.HandleGetWorkoutById)
-		r.Post("/workouts", app.WorkoutHandler.Insert)
-		r.Get("/workouts", app.WorkoutHandler.GetAllWorkouts)
-		r.Put("/workouts/{id}", app.WorkoutHandler.HandleUpdateWorkout)
-		r.Delete("/workouts/{id}", app.WorkoutHandler.HandleDeleteWorkout)
+		r.Get("/workouts/search", app.Middleware.RequireScope(tokens.APIScopeWorkoutsRead, app.WorkoutHandler.HandleSearchWorkouts))
+		r.Get("/workouts/{id}", app.Middleware.RequireScope(tokens.APIScopeWorkoutsRead, app.WorkoutHandler.HandleGetWorkoutById))
+		r.Post("/workouts", app.Middleware.RequireScope(tokens.APIScopeWorkoutsWrite, app.WorkoutHandler.Insert))
+		r.Get("/workouts", app.Middleware.RequireScope(tokens.APIScopeWorkoutsRead, app.WorkoutHandler.GetAllWorkouts))
+		r.Put("/workouts/{id}", app.Middleware.RequireScope(tokens.APIScopeWorkoutsWrite, app.WorkoutHandler.HandleUpdateWorkout))
+		r.Patch("/workouts/{id}", app.Middleware.RequireScope(tokens.APIScopeWorkoutsWrite, app.WorkoutHandler.HandlePatchWorkout))
+		r.Delete("/workouts/{id}", app.Middleware.RequireScope(tokens.APIScopeWorkoutsWrite, app.WorkoutHandler.HandleDeleteWorkout))
+		r.Get("/workouts/{id}/entries", app.Middleware.RequireScope(tokens.APIScopeWorkoutsRead, app.WorkoutHandler.HandleListEntries))
+		r.Post("/workouts/{id}/entries", app.Middleware.RequireScope(tokens.APIScopeWorkoutsWrite, app.WorkoutHandler.HandleCreateEntry))
+		r.Get("/workouts/{id}/entries/{entryId}", app.Middleware.RequireScope(tokens.APIScopeWorkoutsRead, app.WorkoutHandler.HandleGetEntry))
+		r.Patch("/workouts/{id}/entries/{entryId}", app.Middleware.RequireScope(tokens.APIScopeWorkoutsWrite, app.WorkoutHandler.HandlePatchEntry))
+		r.Delete("/workouts/{id}/entries/{entryId}", app.Middleware.RequireScope(tokens.APIScopeWorkoutsWrite, app.WorkoutHandler.HandleDeleteEntry))
 		// users
-		r.Post("/users", app.UserHandler.HandleRegisterUser)
-		r.Get("/users", app.UserHandler.HandleGetUserByUsername)
-		r.Put("/users", app.UserHandler.HandleUpdateUser)
-		r.Get("/users/{id}", app.UserHandler.HandleGetUserByID)
-		r.Delete("/users/{id}", app.UserHandler.HandleDeleteUser)
+		signup.Post("/users", app.UserHandler.HandleRegisterUser)
+		r.Put("/users/activated", app.UserHandler.HandleActivateUser)
+		availability.Get("/users/availability", app.UserHandler.HandleUsernameAvailability)
+		r.Get("/users", app.Middleware.RequirePermission("users:read", app.UserHandler.HandleGetUserByUsername))
+		r.Put("/users", app.Middleware.RequireUser(app.UserHandler.HandleUpdateUser))
+		r.Get("/users/{id}", app.Middleware.RequireSelfOrPermission("users:read", app.UserHandler.HandleGetUserByID))
+		r.Delete("/users/{id}", app.Middleware.RequireSelfOrPermission("users:delete", app.UserHandler.HandleDeleteUser))
+		r.Get("/users/me", app.Middleware.RequireUser(app.UserHandler.HandleGetMe))
+		r.Patch("/users/me", app.Middleware.RequireUser(app.UserHandler.HandleUpdateMe))
+		r.Delete("/users/me", app.Middleware.RequireUser(app.UserHandler.HandleDeleteMe))
+		r.Put("/users/me/avatar", app.Middleware.RequireUser(app.AvatarHandler.HandleUploadAvatar))
+		r.Delete("/users/me/avatar", app.Middleware.RequireUser(app.AvatarHandler.HandleDeleteAvatar))
+		r.Get("/users/{id}/avatar", app.Middleware.RequireUser(app.AvatarHandler.HandleGetAvatar))
+		r.Get("/users/me/export", app.Middleware.RequireUser(app.ExportHandler.HandleExport))
+		r.Put("/users/me/password", app.Middleware.RequireUser(app.UserHandler.HandleChangePassword))
+		r.Post("/users/me/totp", app.Middleware.RequireUser(app.MFAHandler.HandleEnrollTOTP))
+		r.Post("/users/me/totp/confirm", app.Middleware.RequireUser(app.MFAHandler.HandleConfirmTOTP))
+		r.Delete("/users/me/totp", app.Middleware.RequireUser(app.MFAHandler.HandleDisableTOTP))
+		r.Delete("/users/{id}/lockout", app.Middleware.RequirePermission("users:unlock", app.TokenHandler.HandleUnlockUser))
+		// roles
+		r.Get("/users/{id}/roles", app.Middleware.RequireSelfOrPermission("users:read", app.RoleHandler.HandleGetUserRoles))
+		r.Post("/users/{id}/roles", app.Middleware.RequirePermission("roles:assign", app.RoleHandler.HandleAssignRole))
+		r.Delete("/users/{id}/roles/{role}", app.Middleware.RequirePermission("roles:assign", app.RoleHandler.HandleRemoveRole))
 		// tokens
-		r.Post("/tokens", app.TokenHandler.CreateToken)
+		login.Post("/tokens", app.TokenHandler.CreateToken)
+		login.Post("/tokens/mfa", app.TokenHandler.HandleCompleteMFA)
+		refresh.Post("/tokens/refresh", app.TokenHandler.HandleRefreshToken)
+		r.Delete("/tokens", app.Middleware.RequireUser(app.TokenHandler.HandleRevokeAllTokens))
+		r.Delete("/tokens/current", app.Middleware.RequireUser(app.TokenHandler.HandleRevokeCurrentToken))
+		// sessions
+		r.Get("/sessions", app.Middleware.RequireUser(app.TokenHandler.HandleListSessions))
+		r.Delete("/sessions/{id}", app.Middleware.RequireUser(app.TokenHandler.HandleRevokeSession))
+		// api keys
+		r.Post("/api-keys", app.Middleware.RequireUser(app.APIKeyHandler.HandleCreateAPIKey))
+		r.Get("/api-keys", app.Middleware.RequireUser(app.APIKeyHandler.HandleListAPIKeys))
+		r.Delete("/api-keys/{id}", app.Middleware.RequireUser(app.APIKeyHandler.HandleDeleteAPIKey))
+		// metrics
+		r.Get("/metrics", app.Middleware.RequirePermission("metrics:read", expvar.Handler().ServeHTTP))
+		// password reset
+		passwordReset.Post("/password-reset", app.PasswordHandler.HandleRequestPasswordReset)
+		passwordReset.Put("/password-reset", app.PasswordHandler.HandleResetPassword)
 	})
 	return r
 }
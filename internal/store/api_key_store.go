@@ -0,0 +1,128 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       []byte     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+type PostgresAPIKeyStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAPIKeyStore(db *sql.DB) *PostgresAPIKeyStore {
+	return &PostgresAPIKeyStore{db: db}
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(*APIKey) (*APIKey, error)
+	GetAPIKeysForUser(userID int) ([]*APIKey, error)
+	DeleteAPIKey(id int64, userID int) error
+	GetUserForAPIKey(plaintext string) (*User, []string, error)
+}
+
+func (pg *PostgresAPIKeyStore) CreateAPIKey(key *APIKey) (*APIKey, error) {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+	err := pg.db.QueryRow(query, key.UserID, key.Name, key.Prefix, key.Hash, strings.Join(key.Scopes, ","), key.ExpiresAt).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (pg *PostgresAPIKeyStore) GetAPIKeysForUser(userID int) ([]*APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, scopes, created_at, last_used_at, expires_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key := &APIKey{}
+		var scopes string
+		err = rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &key.LastUsedAt, &key.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		key.Scopes = strings.Split(scopes, ",")
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (pg *PostgresAPIKeyStore) DeleteAPIKey(id int64, userID int) error {
+	query := `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`
+	result, err := pg.db.Exec(query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetUserForAPIKey resolves an unexpired key to its owner and scopes and
+// records the time it was used, at most once a minute. It returns a nil user
+// for unknown keys and for accounts that are scheduled for deletion.
+func (pg *PostgresAPIKeyStore) GetUserForAPIKey(plaintext string) (*User, []string, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+	query := `
+		SELECT ` + userColumns("u") + `, k.scopes, k.last_used_at
+		FROM users u
+		INNER JOIN api_keys k ON k.user_id = u.id
+		WHERE k.hash = $1 AND (k.expires_at IS NULL OR k.expires_at > NOW())
+		  AND u.deletion_scheduled_for IS NULL
+	`
+	user := &User{
+		PasswordHash: password{},
+	}
+	var scopes string
+	var lastUsedAt sql.NullTime
+	err := pg.db.QueryRow(query, hash[:]).Scan(append(userDest(user), &scopes, &lastUsedAt)...)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !lastUsedAt.Valid || time.Since(lastUsedAt.Time) > time.Minute {
+		// the condition is repeated so concurrent requests write only once
+		_, err = pg.db.Exec(`
+			UPDATE api_keys SET last_used_at = NOW()
+			WHERE hash = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+		`, hash[:])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return user, strings.Split(scopes, ","), nil
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type PostgresLoginAttemptStore struct {
+	db *sql.DB
+}
+
+func NewPostgresLoginAttemptStore(db *sql.DB) *PostgresLoginAttemptStore {
+	return &PostgresLoginAttemptStore{db: db}
+}
+
+// LoginAttemptStore counts failed logins per key, where a key identifies
+// either an account ("user:<name>") or a client address ("ip:<addr>").
+type LoginAttemptStore interface {
+	GetLockedUntil(key string) (time.Time, error)
+	RecordFailure(key string, window time.Duration) (int, error)
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+}
+
+// GetLockedUntil returns the zero time when the key is not locked.
+func (pg *PostgresLoginAttemptStore) GetLockedUntil(key string) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	query := `SELECT locked_until FROM login_attempts WHERE key = $1`
+	err := pg.db.QueryRow(query, key).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return lockedUntil.Time, nil
+}
+
+// RecordFailure increments the failure counter and returns the new count.
+// The counter starts over when the previous failure is older than window.
+func (pg *PostgresLoginAttemptStore) RecordFailure(key string, window time.Duration) (int, error) {
+	query := `
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_attempts.last_failure_at < NOW() - make_interval(secs => $2) THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING failures
+	`
+	var failures int
+	err := pg.db.QueryRow(query, key, window.Seconds()).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+func (pg *PostgresLoginAttemptStore) Lock(key string, until time.Time) error {
+	query := `UPDATE login_attempts SET locked_until = $2 WHERE key = $1`
+	_, err := pg.db.Exec(query, key, until)
+	return err
+}
+
+func (pg *PostgresLoginAttemptStore) Reset(key string) error {
+	query := `DELETE FROM login_attempts WHERE key = $1`
+	_, err := pg.db.Exec(query, key)
+	return err
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"database/sql"
+	"slices"
+)
+
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+type PostgresPermissionStore struct {
+	db *sql.DB
+}
+
+func NewPostgresPermissionStore(db *sql.DB) *PostgresPermissionStore {
+	return &PostgresPermissionStore{db: db}
+}
+
+type PermissionStore interface {
+	GetAllForUser(userID int) (Permissions, error)
+	GetRolesForUser(userID int) ([]string, error)
+	AssignRole(userID int, role string) error
+	RemoveRole(userID int, role string) error
+}
+
+func (pg *PostgresPermissionStore) GetAllForUser(userID int) (Permissions, error) {
+	query := `
+		SELECT DISTINCT p.code
+		FROM permissions p
+		INNER JOIN roles_permissions rp ON rp.permission_id = p.id
+		INNER JOIN users_roles ur ON ur.role_id = rp.role_id
+		WHERE ur.user_id = $1
+		ORDER BY p.code
+	`
+	return pg.queryStrings(query, userID)
+}
+
+func (pg *PostgresPermissionStore) GetRolesForUser(userID int) ([]string, error) {
+	query := `
+		SELECT r.name
+		FROM roles r
+		INNER JOIN users_roles ur ON ur.role_id = r.id
+		WHERE ur.user_id = $1
+		ORDER BY r.name
+	`
+	return pg.queryStrings(query, userID)
+}
+
+// AssignRole grants a role by name. It returns sql.ErrNoRows when the role
+// does not exist and is a no-op when the user already holds it.
+func (pg *PostgresPermissionStore) AssignRole(userID int, role string) error {
+	var roleID int
+	err := pg.db.QueryRow(`SELECT id FROM roles WHERE name = $1`, role).Scan(&roleID)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO users_roles (user_id, role_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+	_, err = pg.db.Exec(query, userID, roleID)
+	return err
+}
+
+func (pg *PostgresPermissionStore) RemoveRole(userID int, role string) error {
+	query := `
+		DELETE FROM users_roles
+		WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)
+	`
+	result, err := pg.db.Exec(query, userID, role)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (pg *PostgresPermissionStore) queryStrings(query string, args ...any) ([]string, error) {
+	rows, err := pg.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
@@ -1,12 +1,28 @@
 package store
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
 )
 
+var ErrTokenReused = errors.New("refresh token has already been used")
+
+// Session is one login, made up of the access and refresh tokens that share a
+// family. Its ID is assigned on login and kept when the tokens are rotated.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Current    bool       `json:"current"`
+}
+
 type PostgresTokenStore struct {
 	db *sql.DB
 }
@@ -18,7 +34,14 @@ func NewPostgresTokenStore(db *sql.DB) *PostgresTokenStore {
 type TokenStore interface {
 	Insert(token *tokens.Token) error
 	CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error)
+	CreateTokenPair(userID int, family string, client tokens.Client) (*tokens.Token, *tokens.Token, error)
+	RotateRefreshToken(tokenPlainText string, client tokens.Client) (*tokens.Token, *tokens.Token, error)
 	DeleteAllTokensForUser(userID int, scope string) error
+	DeleteToken(hash []byte) error
+	DeleteTokenFamily(hash []byte) error
+	GetSessionsForUser(userID int, currentHash []byte) ([]*Session, error)
+	DeleteSession(id int64, userID int) error
+	DeleteExpiredTokens(limit int) (int64, error)
 }
 
 func (t *PostgresTokenStore) CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
@@ -26,6 +49,10 @@ func (t *PostgresTokenStore) CreateNewToken(userID int, ttl time.Duration, scope
 	if err != nil {
 		return nil, err
 	}
+	toke.Family, err = tokens.NewFamily()
+	if err != nil {
+		return nil, err
+	}
 	err = t.Insert(toke)
 	if err != nil {
 		return nil, err
@@ -33,12 +60,132 @@ func (t *PostgresTokenStore) CreateNewToken(userID int, ttl time.Duration, scope
 	return toke, nil
 }
 
+// CreateTokenPair issues an access token and a refresh token that share a
+// family. An empty family starts a new one, as happens on login.
+func (t *PostgresTokenStore) CreateTokenPair(userID int, family string, client tokens.Client) (*tokens.Token, *tokens.Token, error) {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	access, refresh, err := insertTokenPair(tx, userID, family, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+// RotateRefreshToken marks a refresh token as used and issues a new pair in
+// its family, in one transaction so that a failure leaves the old token
+// usable. It returns nil tokens when the refresh token is unknown or expired,
+// and ErrTokenReused when it was already rotated, which callers should treat
+// as a stolen token.
+func (t *PostgresTokenStore) RotateRefreshToken(tokenPlainText string, client tokens.Client) (*tokens.Token, *tokens.Token, error) {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := consumeRefreshToken(tx, tokenPlainText)
+	if err != nil || old == nil {
+		return nil, nil, err
+	}
+	access, refresh, err := insertTokenPair(tx, old.UserID, old.Family, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+func insertTokenPair(tx *sql.Tx, userID int, family string, client tokens.Client) (*tokens.Token, *tokens.Token, error) {
+	var err error
+	if family == "" {
+		family, err = tokens.NewFamily()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	access, err := tokens.GenerateToken(userID, tokens.AccessTokenTTL, tokens.ScopeAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, err := tokens.GenerateToken(userID, tokens.RefreshTokenTTL, tokens.ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+	access.Family, access.Client = family, client
+	refresh.Family, refresh.Client = family, client
+
+	// the first token of a new family draws the session id and every later
+	// token in the family copies it
+	query := `
+		INSERT INTO token (hash, user_id, expiry, scope, family, user_agent, ip, session_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE(
+			(SELECT session_id FROM token WHERE family = $5 AND session_id IS NOT NULL LIMIT 1),
+			nextval('token_session_id_seq')
+		))
+	`
+	for _, token := range []*tokens.Token{access, refresh} {
+		_, err = tx.Exec(query, token.Hash, token.UserID, token.Expiry, token.Scope, token.Family, token.Client.UserAgent, token.Client.IP)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return access, refresh, nil
+}
+
+func consumeRefreshToken(tx *sql.Tx, tokenPlainText string) (*tokens.Token, error) {
+	hash := sha256.Sum256([]byte(tokenPlainText))
+	token := &tokens.Token{
+		Hash:  hash[:],
+		Scope: tokens.ScopeRefresh,
+	}
+	query := `
+		UPDATE token
+		SET used_at = NOW()
+		WHERE hash = $1 AND scope = $2 AND used_at IS NULL AND expiry > NOW()
+		RETURNING user_id, expiry, family
+	`
+	err := tx.QueryRow(query, token.Hash, token.Scope).Scan(&token.UserID, &token.Expiry, &token.Family)
+	if err == nil {
+		return token, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var usedAt sql.NullTime
+	err = tx.QueryRow(`SELECT used_at FROM token WHERE hash = $1 AND scope = $2`, token.Hash, token.Scope).Scan(&usedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		return nil, ErrTokenReused
+	}
+	return nil, nil
+}
+
 func (t *PostgresTokenStore) Insert(token *tokens.Token) error {
 	query := `
-		INSERT INTO token (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO token (hash, user_id, expiry, scope, family, user_agent, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`
-	_, err := t.db.Exec(query, token.Hash, token.UserID, token.Expiry, token.Scope)
+	_, err := t.db.Exec(query, token.Hash, token.UserID, token.Expiry, token.Scope, token.Family, token.Client.UserAgent, token.Client.IP)
 	return err
 }
 
@@ -50,3 +197,88 @@ func (t *PostgresTokenStore) DeleteAllTokensForUser(userID int, scope string) er
 	_, err := t.db.Exec(query, userID, scope)
 	return err
 }
+
+func (t *PostgresTokenStore) DeleteToken(hash []byte) error {
+	query := `
+		DELETE FROM token
+		WHERE hash = $1
+	`
+	_, err := t.db.Exec(query, hash)
+	return err
+}
+
+// DeleteTokenFamily removes the token with the given hash together with every
+// access and refresh token issued from the same login.
+func (t *PostgresTokenStore) DeleteTokenFamily(hash []byte) error {
+	query := `
+		DELETE FROM token
+		WHERE hash = $1
+		OR family IN (SELECT family FROM token WHERE hash = $1 AND family <> '')
+	`
+	_, err := t.db.Exec(query, hash)
+	return err
+}
+
+// GetSessionsForUser lists logins that still hold a usable refresh token.
+// currentHash marks the session the caller is using.
+func (t *PostgresTokenStore) GetSessionsForUser(userID int, currentHash []byte) ([]*Session, error) {
+	query := `
+		SELECT r.session_id, MIN(f.created_at), MAX(f.last_used_at), r.user_agent, r.ip, r.expiry, BOOL_OR(f.hash = $3)
+		FROM token r
+		INNER JOIN token f ON f.family = r.family
+		WHERE r.user_id = $1 AND r.scope = $2 AND r.used_at IS NULL AND r.expiry > NOW() AND r.family <> ''
+		GROUP BY r.session_id, r.user_agent, r.ip, r.expiry
+		ORDER BY MIN(f.created_at) DESC
+	`
+	rows, err := t.db.Query(query, userID, tokens.ScopeRefresh, currentHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		session := &Session{}
+		err = rows.Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt, &session.UserAgent, &session.IP, &session.ExpiresAt, &session.Current)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteSession revokes every token of the session, provided it belongs to
+// userID.
+func (t *PostgresTokenStore) DeleteSession(id int64, userID int) error {
+	query := `
+		DELETE FROM token
+		WHERE session_id = $1 AND user_id = $2
+	`
+	result, err := t.db.Exec(query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteExpiredTokens removes at most limit expired tokens and returns how
+// many were deleted.
+func (t *PostgresTokenStore) DeleteExpiredTokens(limit int) (int64, error) {
+	query := `
+		DELETE FROM token
+		WHERE hash IN (SELECT hash FROM token WHERE expiry < NOW() LIMIT $1)
+	`
+	result, err := t.db.Exec(query, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
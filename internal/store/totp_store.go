@@ -0,0 +1,156 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type TOTP struct {
+	UserID       int
+	Secret       string
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+}
+
+func (t *TOTP) Enabled() bool {
+	return t != nil && t.ConfirmedAt != nil
+}
+
+type PostgresTOTPStore struct {
+	db *sql.DB
+}
+
+func NewPostgresTOTPStore(db *sql.DB) *PostgresTOTPStore {
+	return &PostgresTOTPStore{db: db}
+}
+
+type TOTPStore interface {
+	GetTOTP(userID int) (*TOTP, error)
+	SaveUnconfirmedTOTP(userID int, secret string) error
+	ConfirmTOTP(userID int, step int64, recoveryCodeHashes [][]byte) error
+	UseStep(userID int, step int64) (bool, error)
+	UseRecoveryCode(userID int, codeHash []byte) (bool, error)
+	DeleteTOTP(userID int) error
+}
+
+// GetTOTP returns nil when the user has never started enrollment.
+func (pg *PostgresTOTPStore) GetTOTP(userID int) (*TOTP, error) {
+	totp := &TOTP{}
+	query := `
+		SELECT user_id, secret, confirmed_at, last_used_step
+		FROM user_totp
+		WHERE user_id = $1
+	`
+	err := pg.db.QueryRow(query, userID).Scan(&totp.UserID, &totp.Secret, &totp.ConfirmedAt, &totp.LastUsedStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return totp, nil
+}
+
+func (pg *PostgresTOTPStore) SaveUnconfirmedTOTP(userID int, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, confirmed_at = NULL, last_used_step = 0, created_at = NOW()
+	`
+	_, err := pg.db.Exec(query, userID, secret)
+	return err
+}
+
+// ConfirmTOTP enables the pending secret and replaces the user's recovery
+// codes in one transaction.
+func (pg *PostgresTOTPStore) ConfirmTOTP(userID int, step int64, recoveryCodeHashes [][]byte) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE user_totp
+		SET confirmed_at = NOW(), last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL
+	`, userID, step)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec(`INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records step as the last accepted code and reports false when a
+// code from that step or a later one was already used.
+func (pg *PostgresTOTPStore) UseStep(userID int, step int64) (bool, error) {
+	query := `
+		UPDATE user_totp
+		SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2
+	`
+	result, err := pg.db.Exec(query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+func (pg *PostgresTOTPStore) UseRecoveryCode(userID int, codeHash []byte) (bool, error) {
+	query := `
+		UPDATE totp_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+	result, err := pg.db.Exec(query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func (pg *PostgresTOTPStore) DeleteTOTP(userID int) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,19 +1,45 @@
 package store
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"errors"
+	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/alireza-akbarzadeh/fem_project/internal/passwords"
+	"github.com/alireza-akbarzadeh/fem_project/internal/tokens"
+	"github.com/jackc/pgconn"
 )
 
+var (
+	ErrDuplicateUsername = errors.New("username is already taken")
+	ErrDuplicateEmail    = errors.New("email is already registered")
+)
+
+// uniqueViolation translates unique constraint failures on users into
+// ErrDuplicateUsername or ErrDuplicateEmail and returns other errors as is.
+func uniqueViolation(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return err
+	}
+	switch {
+	case strings.Contains(pgErr.ConstraintName, "username"):
+		return ErrDuplicateUsername
+	case strings.Contains(pgErr.ConstraintName, "email"):
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
 type password struct {
 	plaintext *string
 	Hash      []byte
 }
 
 func (p *password) Set(plaintext string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), bcrypt.DefaultCost)
+	hash, err := passwords.Hash(plaintext)
 	if err != nil {
 		return err
 	}
@@ -22,16 +48,13 @@ func (p *password) Set(plaintext string) error {
 	return nil
 }
 func (p *password) Matches(plaintext string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.Hash, []byte(plaintext))
-	if err != nil {
-		switch err {
-		case bcrypt.ErrMismatchedHashAndPassword:
-			return false, nil
-		default:
-			return false, err
-		}
-	}
-	return true, nil
+	return passwords.Verify(plaintext, p.Hash)
+}
+
+// NeedsRehash reports whether the stored hash uses an outdated algorithm or
+// parameters and should be replaced after the next successful login.
+func (p *password) NeedsRehash() bool {
+	return passwords.NeedsRehash(p.Hash)
 }
 
 func (p *password) Clear() {
@@ -46,8 +69,71 @@ type User struct {
 	Password     string    `json:"-"`
 	PasswordHash password  `json:"-"`
 	Bio          string    `json:"bio"`
+	DisplayName  string    `json:"display_name"`
+	DateOfBirth  *Date     `json:"date_of_birth"`
+	WeightUnit   string    `json:"weight_unit"`
+	DistanceUnit string    `json:"distance_unit"`
+	Timezone     string    `json:"timezone"`
+	HeightCM     *float64  `json:"height_cm"`
+	AvatarKey    string    `json:"-"`
+	Activated    bool      `json:"activated"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
+	// DeletionScheduledFor is set when the user asked for their account to
+	// be deleted. Logging in before then cancels the deletion.
+	DeletionScheduledFor *time.Time `json:"deletion_scheduled_for,omitempty"`
+}
+
+// Units a user can choose for display. Measurements are always stored in
+// kilograms and centimetres.
+const (
+	WeightUnitKG   = "kg"
+	WeightUnitLB   = "lb"
+	DistanceUnitKM = "km"
+	DistanceUnitMI = "mi"
+)
+
+const DefaultTimezone = "UTC"
+
+// userColumnNames are read by every query that loads a full User, in the
+// order userDest expects them.
+var userColumnNames = []string{
+	"id", "username", "email", "password_hash", "bio", "activated", "created_at", "updated_at", "deletion_scheduled_for",
+	"display_name", "date_of_birth", "weight_unit", "distance_unit", "timezone", "height_cm", "avatar_key",
+}
+
+// userColumns returns userColumnNames qualified with table, for use in a
+// SELECT list.
+func userColumns(table string) string {
+	return table + "." + strings.Join(userColumnNames, ", "+table+".")
+}
+
+// userDest returns the scan destinations matching userColumnNames.
+func userDest(user *User) []any {
+	return []any{
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash.Hash,
+		&user.Bio,
+		&user.Activated,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.DeletionScheduledFor,
+		&user.DisplayName,
+		&user.DateOfBirth,
+		&user.WeightUnit,
+		&user.DistanceUnit,
+		&user.Timezone,
+		&user.HeightCM,
+		&user.AvatarKey,
+	}
+}
+
+var AnonymousUser = &User{}
+
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
 }
 
 type PostgresUserStore struct {
@@ -60,41 +146,115 @@ func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
 
 type UserStore interface {
 	CreateUser(*User) (*User, error)
+	CreateUserWithActivation(user *User, role string) (*tokens.Token, error)
 	GetUserByUserName(username string) (*User, error)
 	UpdateUser(*User) error
 	GetUserByID(id int64) (*User, error)
 	DeleteUser(id int64) error
+	GetUserToken(scope, tokenPlainText string) (*User, error)
+	GetUserByEmail(email string) (*User, error)
+	UpdatePassword(userID int, passwordHash []byte) error
+	ChangePassword(userID int, passwordHash []byte, keep int) error
+	GetPasswordHistory(userID int, limit int) ([][]byte, error)
+	ActivateUser(userID int) error
+	IsUsernameTaken(username string) (bool, error)
+	SetAvatarKey(userID int, key string) error
+	ScheduleDeletion(userID int, at time.Time) error
+	CancelDeletion(userID int) error
+	DeleteScheduledUsers(limit int) (int64, []string, error)
 }
 
 func (pg *PostgresUserStore) CreateUser(user *User) (*User, error) {
-	query := `
-		INSERT INTO users (username, email, password_hash, bio, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, NOW(), NOW())
-		RETURNING id, created_at, updated_at
-		`
-	err := pg.db.QueryRow(query, user.Username, user.Email, user.PasswordHash.Hash, user.Bio).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	err := insertUser(pg.db, user)
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (pg *PostgresUserStore) GetUserByUserName(username string) (*User, error) {
-	user := &User{
-		PasswordHash: password{},
+// CreateUserWithActivation inserts the user, grants it role and issues its
+// activation token in one transaction, so that a failed registration leaves
+// no account behind. It returns sql.ErrNoRows when the role does not exist.
+func (pg *PostgresUserStore) CreateUserWithActivation(user *User, role string) (*tokens.Token, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
 	}
-	query := `SELECT id, username, email, password_hash, bio, created_at, updated_at
-			  FROM users
-			  WHERE username = $1`
-	err := pg.db.QueryRow(query, username).Scan(
+	defer tx.Rollback()
+
+	err = insertUser(tx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO users_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = $2
+	`
+	result, err := tx.Exec(query, user.ID, role)
+	if err != nil {
+		return nil, err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowAffected == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	token, err := tokens.GenerateToken(user.ID, tokens.ActivationTokenTTL, tokens.ScopeActivation)
+	if err != nil {
+		return nil, err
+	}
+	token.Family, err = tokens.NewFamily()
+	if err != nil {
+		return nil, err
+	}
+	query = `
+		INSERT INTO token (hash, user_id, expiry, scope, family)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err = tx.Exec(query, token.Hash, token.UserID, token.Expiry, token.Scope, token.Family)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func insertUser(q queryRower, user *User) error {
+	query := `
+		INSERT INTO users (username, email, password_hash, bio, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		RETURNING id, activated, created_at, updated_at, display_name, weight_unit, distance_unit, timezone
+		`
+	err := q.QueryRow(query, user.Username, user.Email, user.PasswordHash.Hash, user.Bio).Scan(
 		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash.Hash,
-		&user.Bio,
+		&user.Activated,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.DisplayName,
+		&user.WeightUnit,
+		&user.DistanceUnit,
+		&user.Timezone,
 	)
+	return uniqueViolation(err)
+}
+
+// GetUserByUserName looks the user up case-insensitively.
+func (pg *PostgresUserStore) GetUserByUserName(username string) (*User, error) {
+	user := &User{
+		PasswordHash: password{},
+	}
+	query := `SELECT ` + userColumns("users") + `
+			  FROM users
+			  WHERE LOWER(username) = LOWER($1)`
+	err := pg.db.QueryRow(query, username).Scan(userDest(user)...)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -109,18 +269,10 @@ func (pg *PostgresUserStore) GetUserByID(id int64) (*User, error) {
 	user := &User{
 		PasswordHash: password{},
 	}
-	query := `SELECT id, username, email, password_hash, bio, created_at, updated_at
+	query := `SELECT ` + userColumns("users") + `
 			  FROM users
 			  WHERE id = $1`
-	err := pg.db.QueryRow(query, id).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash.Hash,
-		&user.Bio,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	err := pg.db.QueryRow(query, id).Scan(userDest(user)...)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -134,11 +286,88 @@ func (pg *PostgresUserStore) GetUserByID(id int64) (*User, error) {
 func (pg *PostgresUserStore) UpdateUser(user *User) error {
 	query := `
 		UPDATE users
-		SET username = $1, email = $2, bio = $3, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $4
-		Returning updated_at,id
+		SET username = $1, email = $2, bio = $3, display_name = $4, date_of_birth = $5,
+			weight_unit = $6, distance_unit = $7, timezone = $8, height_cm = $9, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $10
+		RETURNING updated_at
+	`
+	err := pg.db.QueryRow(query,
+		user.Username,
+		user.Email,
+		user.Bio,
+		user.DisplayName,
+		user.DateOfBirth,
+		user.WeightUnit,
+		user.DistanceUnit,
+		user.Timezone,
+		user.HeightCM,
+		user.ID,
+	).Scan(&user.UpdatedAt)
+	return uniqueViolation(err)
+}
+
+func (pg *PostgresUserStore) DeleteUser(id int64) error {
+	query := `DELETE FROM users WHERE id = $1`
+	_, err := pg.db.Exec(query, id)
+	return err
+}
+
+// GetUserToken returns the owner of an unexpired token with the given scope
+// and records when the token was last used, at most once a minute.
+func (pg *PostgresUserStore) GetUserToken(scope, tokenPlainText string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+	query := `
+		SELECT ` + userColumns("u") + `, t.last_used_at
+		FROM users u
+		INNER JOIN token t ON t.user_id = u.id
+		WHERE t.hash = $1 AND t.scope = $2 AND t.expiry > $3
 	`
-	result, err := pg.db.Exec(query, user.Username, user.Email, user.Bio, user.ID)
+	user := &User{
+		PasswordHash: password{},
+	}
+	var lastUsedAt sql.NullTime
+	err := pg.db.QueryRow(query, tokenHash[:], scope, time.Now()).Scan(append(userDest(user), &lastUsedAt)...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !lastUsedAt.Valid || time.Since(lastUsedAt.Time) > time.Minute {
+		_, err = pg.db.Exec(`UPDATE token SET last_used_at = NOW() WHERE hash = $1`, tokenHash[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// GetUserByEmail looks the user up case-insensitively.
+func (pg *PostgresUserStore) GetUserByEmail(email string) (*User, error) {
+	user := &User{
+		PasswordHash: password{},
+	}
+	query := `SELECT ` + userColumns("users") + `
+			  FROM users
+			  WHERE LOWER(email) = LOWER($1)`
+	err := pg.db.QueryRow(query, email).Scan(userDest(user)...)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (pg *PostgresUserStore) UpdatePassword(userID int, passwordHash []byte) error {
+	query := `
+		UPDATE users
+		SET password_hash = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+	result, err := pg.db.Exec(query, passwordHash, userID)
 	if err != nil {
 		return err
 	}
@@ -152,8 +381,198 @@ func (pg *PostgresUserStore) UpdateUser(user *User) error {
 	return nil
 }
 
-func (pg *PostgresUserStore) DeleteUser(id int64) error {
-	query := `DELETE FROM users WHERE id = $1`
-	_, err := pg.db.Exec(query, id)
+// ChangePassword replaces the user's password and moves the old hash into
+// password_history, keeping only the most recent keep entries.
+func (pg *PostgresUserStore) ChangePassword(userID int, passwordHash []byte, keep int) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO password_history (user_id, password_hash)
+		SELECT id, password_hash FROM users WHERE id = $1
+	`
+	result, err := tx.Exec(query, userID)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	query = `
+		UPDATE users
+		SET password_hash = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+	_, err = tx.Exec(query, passwordHash, userID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM password_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history
+			WHERE user_id = $1
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2
+		)
+	`
+	_, err = tx.Exec(query, userID, keep)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetPasswordHistory returns up to limit previous password hashes, newest
+// first. The current hash is not included.
+func (pg *PostgresUserStore) GetPasswordHistory(userID int, limit int) ([][]byte, error) {
+	query := `
+		SELECT password_hash FROM password_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+	rows, err := pg.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes [][]byte
+	for rows.Next() {
+		var hash []byte
+		err = rows.Scan(&hash)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+func (pg *PostgresUserStore) ActivateUser(userID int) error {
+	query := `
+		UPDATE users
+		SET activated = TRUE, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`
+	result, err := pg.db.Exec(query, userID)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (pg *PostgresUserStore) IsUsernameTaken(username string) (bool, error) {
+	var taken bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(username) = LOWER($1))`
+	err := pg.db.QueryRow(query, username).Scan(&taken)
+	return taken, err
+}
+
+// SetAvatarKey records the blob key prefix of the user's avatar. An empty
+// key removes the avatar.
+func (pg *PostgresUserStore) SetAvatarKey(userID int, key string) error {
+	query := `
+		UPDATE users
+		SET avatar_key = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+	result, err := pg.db.Exec(query, key, userID)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (pg *PostgresUserStore) ScheduleDeletion(userID int, at time.Time) error {
+	query := `
+		UPDATE users
+		SET deletion_scheduled_for = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+	result, err := pg.db.Exec(query, at, userID)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (pg *PostgresUserStore) CancelDeletion(userID int) error {
+	query := `
+		UPDATE users
+		SET deletion_scheduled_for = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deletion_scheduled_for IS NOT NULL
+	`
+	_, err := pg.db.Exec(query, userID)
 	return err
 }
+
+// DeleteScheduledUsers removes up to limit users whose grace period has
+// ended and returns how many were deleted along with the avatar key
+// prefixes they left behind.
+func (pg *PostgresUserStore) DeleteScheduledUsers(limit int) (int64, []string, error) {
+	query := `
+		DELETE FROM users
+		WHERE id IN (
+			SELECT id FROM users
+			WHERE deletion_scheduled_for <= NOW()
+			LIMIT $1
+		)
+		RETURNING avatar_key
+	`
+	rows, err := pg.db.Query(query, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var deleted int64
+	var avatarKeys []string
+	for rows.Next() {
+		var avatarKey string
+		err = rows.Scan(&avatarKey)
+		if err != nil {
+			return 0, nil, err
+		}
+		deleted++
+		if avatarKey != "" {
+			avatarKeys = append(avatarKeys, avatarKey)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	return deleted, avatarKeys, nil
+}
@@ -0,0 +1,302 @@
+package store
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"math"
+	"slices"
+)
+
+// ErrWorkoutEntryNotFound is returned by UpdateWorkout when an entry carries
+// an id that does not belong to the workout, or appears twice.
+var ErrWorkoutEntryNotFound = errors.New("workout entry not found")
+
+// Entries of a workout are kept at contiguous 1-based order_index positions.
+// Every method that changes entries locks the workout row first, so
+// concurrent edits of the same workout cannot leave gaps or duplicates.
+
+const entryColumns = `id, workout_id, exercise_name, sets, reps, duration_second, weight, notes, order_index, created_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWorkoutEntry(row rowScanner, entry *WorkoutEntry) error {
+	return row.Scan(&entry.ID, &entry.WorkoutID, &entry.ExerciseName, &entry.Sets, &entry.Reps, &entry.DurationSeconds, &entry.Weight, &entry.Notes, &entry.OrderIndex, &entry.CreatedAt)
+}
+
+// normalizeEntryOrder sorts entries by the order_index the client sent,
+// keeping entries without one at the end, and renumbers them from 1.
+func normalizeEntryOrder(entries []WorkoutEntry) {
+	position := func(e WorkoutEntry) int {
+		if e.OrderIndex <= 0 {
+			return math.MaxInt
+		}
+		return e.OrderIndex
+	}
+	slices.SortStableFunc(entries, func(a, b WorkoutEntry) int {
+		return cmp.Compare(position(a), position(b))
+	})
+	for i := range entries {
+		entries[i].OrderIndex = i + 1
+	}
+}
+
+// touchWorkout bumps the workout's version and updated_at, which also locks
+// its row until the transaction ends.
+func touchWorkout(tx *sql.Tx, workoutID int64) error {
+	result, err := tx.Exec(`UPDATE workouts SET updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $1`, workoutID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func countWorkoutEntries(tx *sql.Tx, workoutID int64) (int, error) {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM workouts_entries WHERE workout_id = $1`, workoutID).Scan(&count)
+	return count, err
+}
+
+func insertWorkoutEntry(tx *sql.Tx, entry *WorkoutEntry) error {
+	query := `
+		INSERT INTO workouts_entries
+			(workout_id, exercise_name, sets, reps, duration_second, weight, notes, order_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`
+	return tx.QueryRow(query,
+		entry.WorkoutID,
+		entry.ExerciseName,
+		entry.Sets,
+		entry.Reps,
+		entry.DurationSeconds,
+		entry.Weight,
+		entry.Notes,
+		entry.OrderIndex,
+	).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+func updateWorkoutEntryRow(tx *sql.Tx, entry *WorkoutEntry) error {
+	query := `
+		UPDATE workouts_entries
+		SET exercise_name=$1, sets=$2, reps=$3, duration_second=$4, weight=$5, notes=$6, order_index=$7
+		WHERE id=$8 AND workout_id=$9
+		RETURNING created_at
+	`
+	return tx.QueryRow(query,
+		entry.ExerciseName,
+		entry.Sets,
+		entry.Reps,
+		entry.DurationSeconds,
+		entry.Weight,
+		entry.Notes,
+		entry.OrderIndex,
+		entry.ID,
+		entry.WorkoutID,
+	).Scan(&entry.CreatedAt)
+}
+
+// syncWorkoutEntries makes the stored entries of workout match
+// workout.Entries: entries with an id are updated in place, entries without
+// one are inserted and stored entries missing from the list are deleted.
+func syncWorkoutEntries(tx *sql.Tx, workout *Workout) error {
+	rows, err := tx.Query(`SELECT id FROM workouts_entries WHERE workout_id = $1`, workout.ID)
+	if err != nil {
+		return err
+	}
+	existing := map[int]bool{}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		existing[id] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	normalizeEntryOrder(workout.Entries)
+	for i := range workout.Entries {
+		entry := &workout.Entries[i]
+		entry.WorkoutID = workout.ID
+		if entry.ID == 0 {
+			err = insertWorkoutEntry(tx, entry)
+		} else if existing[entry.ID] {
+			delete(existing, entry.ID)
+			err = updateWorkoutEntryRow(tx, entry)
+		} else {
+			return ErrWorkoutEntryNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	removed := make([]int64, 0, len(existing))
+	for id := range existing {
+		removed = append(removed, int64(id))
+	}
+	if len(removed) > 0 {
+		_, err = tx.Exec(`DELETE FROM workouts_entries WHERE workout_id = $1 AND id = ANY($2)`, workout.ID, removed)
+	}
+	return err
+}
+
+func (pg *PostgresWorkoutStore) GetWorkoutEntries(workoutID int64) ([]WorkoutEntry, error) {
+	query := `SELECT ` + entryColumns + ` FROM workouts_entries WHERE workout_id = $1 ORDER BY order_index, id`
+	rows, err := pg.db.Query(query, workoutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []WorkoutEntry{}
+	for rows.Next() {
+		var entry WorkoutEntry
+		err = scanWorkoutEntry(rows, &entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (pg *PostgresWorkoutStore) GetWorkoutEntry(workoutID, entryID int64) (*WorkoutEntry, error) {
+	entry := &WorkoutEntry{}
+	query := `SELECT ` + entryColumns + ` FROM workouts_entries WHERE id = $1 AND workout_id = $2`
+	err := scanWorkoutEntry(pg.db.QueryRow(query, entryID, workoutID), entry)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// CreateWorkoutEntry inserts entry at its OrderIndex, shifting the entries
+// from that position down. Without a valid position it is appended.
+func (pg *PostgresWorkoutStore) CreateWorkoutEntry(entry *WorkoutEntry) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	workoutID := int64(entry.WorkoutID)
+	err = touchWorkout(tx, workoutID)
+	if err != nil {
+		return err
+	}
+	count, err := countWorkoutEntries(tx, workoutID)
+	if err != nil {
+		return err
+	}
+	if entry.OrderIndex < 1 || entry.OrderIndex > count+1 {
+		entry.OrderIndex = count + 1
+	}
+
+	_, err = tx.Exec(`
+		UPDATE workouts_entries SET order_index = order_index + 1
+		WHERE workout_id = $1 AND order_index >= $2
+	`, workoutID, entry.OrderIndex)
+	if err != nil {
+		return err
+	}
+	err = insertWorkoutEntry(tx, entry)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateWorkoutEntry updates entry in place. A changed OrderIndex moves it,
+// shifting the entries in between; positions past the end are clamped.
+func (pg *PostgresWorkoutStore) UpdateWorkoutEntry(entry *WorkoutEntry) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	workoutID := int64(entry.WorkoutID)
+	err = touchWorkout(tx, workoutID)
+	if err != nil {
+		return err
+	}
+	var current int
+	err = tx.QueryRow(`SELECT order_index FROM workouts_entries WHERE id = $1 AND workout_id = $2`, entry.ID, workoutID).Scan(&current)
+	if err != nil {
+		return err
+	}
+	count, err := countWorkoutEntries(tx, workoutID)
+	if err != nil {
+		return err
+	}
+	entry.OrderIndex = min(max(entry.OrderIndex, 1), count)
+
+	switch {
+	case entry.OrderIndex < current:
+		_, err = tx.Exec(`
+			UPDATE workouts_entries SET order_index = order_index + 1
+			WHERE workout_id = $1 AND order_index >= $2 AND order_index < $3
+		`, workoutID, entry.OrderIndex, current)
+	case entry.OrderIndex > current:
+		_, err = tx.Exec(`
+			UPDATE workouts_entries SET order_index = order_index - 1
+			WHERE workout_id = $1 AND order_index > $2 AND order_index <= $3
+		`, workoutID, current, entry.OrderIndex)
+	}
+	if err != nil {
+		return err
+	}
+	err = updateWorkoutEntryRow(tx, entry)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteWorkoutEntry removes the entry and closes the gap it leaves.
+func (pg *PostgresWorkoutStore) DeleteWorkoutEntry(workoutID, entryID int64) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = touchWorkout(tx, workoutID)
+	if err != nil {
+		return err
+	}
+	var position int
+	err = tx.QueryRow(`DELETE FROM workouts_entries WHERE id = $1 AND workout_id = $2 RETURNING order_index`, entryID, workoutID).Scan(&position)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE workouts_entries SET order_index = order_index - 1
+		WHERE workout_id = $1 AND order_index > $2
+	`, workoutID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
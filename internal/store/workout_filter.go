@@ -0,0 +1,157 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WorkoutSortFields maps the sort keys accepted by GET /workouts to the SQL
+// expression ordered on and the type used to compare cursor values.
+var WorkoutSortFields = map[string]struct {
+	expr string
+	cast string
+}{
+	"id":               {expr: "id", cast: "bigint"},
+	"title":            {expr: "title", cast: "text"},
+	"duration_minutes": {expr: "duration_minutes", cast: "integer"},
+	"calories_burned":  {expr: "COALESCE(calories_burned, 0)", cast: "integer"},
+	"created_at":       {expr: "created_at", cast: "timestamptz"},
+}
+
+const DefaultWorkoutSort = "-created_at"
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// WorkoutFilter narrows and orders a user's workouts. Zero values mean "no
+// filter". Cursor, when set, takes precedence over Page.
+type WorkoutFilter struct {
+	Title         string
+	MinDuration   *int
+	MaxDuration   *int
+	MinCalories   *int
+	MaxCalories   *int
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+
+	// Sort is a key of WorkoutSortFields, prefixed with "-" for descending
+	// order. Ties are broken by id in the same direction.
+	Sort     string
+	Page     int
+	PageSize int
+	Cursor   string
+}
+
+// Metadata describes the page of results returned alongside a list.
+type Metadata struct {
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+}
+
+func newMetadata(total, page, pageSize int, cursorMode bool) Metadata {
+	m := Metadata{PageSize: pageSize, TotalRecords: total}
+	if cursorMode {
+		return m
+	}
+	m.CurrentPage = page
+	m.FirstPage = 1
+	m.LastPage = max(1, int(math.Ceil(float64(total)/float64(pageSize))))
+	return m
+}
+
+// SortColumn returns the sort key without its direction prefix.
+func (f WorkoutFilter) SortColumn() string {
+	return strings.TrimPrefix(f.Sort, "-")
+}
+
+func (f WorkoutFilter) SortDescending() bool {
+	return strings.HasPrefix(f.Sort, "-")
+}
+
+// workoutCursor points just past the last row of a page. It is tied to the
+// sort order it was produced for.
+type workoutCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeCursor(c workoutCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s, sort string) (workoutCursor, error) {
+	var c workoutCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	err = json.Unmarshal(data, &c)
+	if err != nil || c.Sort != sort {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// cursorValue renders the sort column of w the way the matching cast in
+// WorkoutSortFields reads it back.
+func cursorValue(w *Workout, column string) string {
+	switch column {
+	case "title":
+		return w.Title
+	case "duration_minutes":
+		return strconv.Itoa(w.DurationMinutes)
+	case "calories_burned":
+		return strconv.Itoa(w.CaloriesBurned)
+	case "created_at":
+		return w.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		return strconv.Itoa(w.ID)
+	}
+}
+
+// whereClause builds the SQL conditions for the filter, numbering
+// placeholders after the ones already in args.
+func (f WorkoutFilter) whereClause(args []any) (string, []any) {
+	var conds []string
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Title != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(f.Title)
+		add("title ILIKE '%%' || $%d || '%%'", escaped)
+	}
+	if f.MinDuration != nil {
+		add("duration_minutes >= $%d", *f.MinDuration)
+	}
+	if f.MaxDuration != nil {
+		add("duration_minutes <= $%d", *f.MaxDuration)
+	}
+	if f.MinCalories != nil {
+		add("COALESCE(calories_burned, 0) >= $%d", *f.MinCalories)
+	}
+	if f.MaxCalories != nil {
+		add("COALESCE(calories_burned, 0) <= $%d", *f.MaxCalories)
+	}
+	if f.CreatedAfter != nil {
+		add("created_at >= $%d", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		add("created_at < $%d", *f.CreatedBefore)
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " AND " + strings.Join(conds, " AND "), args
+}
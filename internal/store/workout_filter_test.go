@@ -0,0 +1,43 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestWorkoutFilterWhereClause(t *testing.T) {
+	min, max := 10, 60
+	after := time.Date(2025, time.May, 1, 0, 0, 0, 0, time.UTC)
+	filter := WorkoutFilter{Title: "100%_leg", MinDuration: &min, MaxCalories: &max, CreatedAfter: &after}
+
+	where, args := filter.whereClause([]any{7})
+	assert.Equal(t, " AND title ILIKE '%' || $2 || '%' AND duration_minutes >= $3 AND COALESCE(calories_burned, 0) <= $4 AND created_at >= $5", where)
+	assert.Equal(t, []any{7, `100\%\_leg`, 10, 60, after}, args)
+
+	where, args = WorkoutFilter{}.whereClause([]any{7})
+	assert.Empty(t, where)
+	assert.Equal(t, []any{7}, args)
+}
+
+func TestWorkoutCursor(t *testing.T) {
+	c := workoutCursor{Sort: "-created_at", Value: "2025-05-01T10:00:00Z", ID: 42}
+	encoded := encodeCursor(c)
+
+	decoded, err := decodeCursor(encoded, "-created_at")
+	require.NoError(t, err)
+	assert.Equal(t, c, decoded)
+
+	_, err = decodeCursor(encoded, "title")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, err = decodeCursor("not a cursor!", "-created_at")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestNewMetadata(t *testing.T) {
+	assert.Equal(t, Metadata{CurrentPage: 2, PageSize: 20, FirstPage: 1, LastPage: 3, TotalRecords: 41}, newMetadata(41, 2, 20, false))
+	assert.Equal(t, Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1}, newMetadata(0, 1, 20, false))
+	assert.Equal(t, Metadata{PageSize: 20, TotalRecords: 41}, newMetadata(41, 1, 20, true))
+}
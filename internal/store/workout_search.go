@@ -0,0 +1,274 @@
+package store
+
+import (
+	"cmp"
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const (
+	DefaultWorkoutSearchLimit = 20
+	MaxWorkoutSearchLimit     = 50
+)
+
+// SearchHighlight is a fragment of a matching field. Matched terms are wrapped
+// in <mark> tags and the rest of the snippet is HTML-escaped, so clients can
+// render it as is.
+type SearchHighlight struct {
+	Field   string `json:"field"`
+	EntryID int    `json:"entry_id,omitempty"`
+	Snippet string `json:"snippet"`
+}
+
+type WorkoutSearchResult struct {
+	Workout    *Workout          `json:"workout"`
+	Rank       float64           `json:"rank"`
+	Highlights []SearchHighlight `json:"highlights"`
+}
+
+// ts_headline marks matches with private use characters rather than tags so
+// that the surrounding text can still be escaped afterwards.
+const (
+	headlineStart   = "\uE000"
+	headlineStop    = "\uE001"
+	headlineOptions = "StartSel=" + headlineStart + ", StopSel=" + headlineStop + `, MinWords=10, MaxWords=30, MaxFragments=2, FragmentDelimiter=" ... "`
+)
+
+var headlineReplacer = strings.NewReplacer(headlineStart, "<mark>", headlineStop, "</mark>")
+
+func renderHeadline(headline string) string {
+	return headlineReplacer.Replace(html.EscapeString(headline))
+}
+
+// SearchWorkouts runs a web-search style query (quoted phrases, "or", -term)
+// over the title and description of the user's workouts and the exercise
+// names and notes of their entries. A workout ranks by the sum of the ranks
+// of itself and its matching entries.
+func (pg *PostgresWorkoutStore) SearchWorkouts(userID int, query string, limit int) ([]*WorkoutSearchResult, error) {
+	sqlQuery := `
+		WITH q AS (SELECT websearch_to_tsquery('english', $2) AS query),
+		matches AS (
+			SELECT w.id, ts_rank(w.search_vector, q.query) AS rank
+			FROM workouts w, q
+			WHERE w.user_id = $1 AND w.search_vector @@ q.query
+			UNION ALL
+			SELECT e.workout_id, ts_rank(e.search_vector, q.query)
+			FROM workouts_entries e JOIN workouts w ON w.id = e.workout_id, q
+			WHERE w.user_id = $1 AND e.search_vector @@ q.query
+		),
+		ranked AS (
+			SELECT id, SUM(rank) AS rank
+			FROM matches
+			GROUP BY id
+			ORDER BY rank DESC, id DESC
+			LIMIT $3
+		)
+		SELECT w.id, w.user_id, w.title, COALESCE(w.description, ''), w.duration_minutes, COALESCE(w.calories_burned, 0),
+			w.created_at, w.updated_at, w.version, r.rank,
+			CASE WHEN to_tsvector('english', w.title) @@ q.query
+				THEN ts_headline('english', w.title, q.query, $4) ELSE '' END,
+			CASE WHEN to_tsvector('english', COALESCE(w.description, '')) @@ q.query
+				THEN ts_headline('english', w.description, q.query, $4) ELSE '' END
+		FROM ranked r JOIN workouts w ON w.id = r.id, q
+		ORDER BY r.rank DESC, w.id DESC
+	`
+	rows, err := pg.db.Query(sqlQuery, userID, query, limit, headlineOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*WorkoutSearchResult{}
+	byID := map[int]*WorkoutSearchResult{}
+	var ids []int64
+	for rows.Next() {
+		workout := &Workout{}
+		result := &WorkoutSearchResult{Workout: workout, Highlights: []SearchHighlight{}}
+		var title, description string
+		err = rows.Scan(&workout.ID, &workout.UserID, &workout.Title, &workout.Description, &workout.DurationMinutes, &workout.CaloriesBurned,
+			&workout.CreatedAt, &workout.UpdatedAt, &workout.Version, &result.Rank, &title, &description)
+		if err != nil {
+			return nil, err
+		}
+		if title != "" {
+			result.Highlights = append(result.Highlights, SearchHighlight{Field: "title", Snippet: renderHeadline(title)})
+		}
+		if description != "" {
+			result.Highlights = append(result.Highlights, SearchHighlight{Field: "description", Snippet: renderHeadline(description)})
+		}
+		results = append(results, result)
+		byID[workout.ID] = result
+		ids = append(ids, int64(workout.ID))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return results, nil
+	}
+
+	entryQuery := `
+		WITH q AS (SELECT websearch_to_tsquery('english', $2) AS query)
+		SELECT e.workout_id, e.id,
+			CASE WHEN to_tsvector('english', e.exercise_name) @@ q.query
+				THEN ts_headline('english', e.exercise_name, q.query, $3) ELSE '' END,
+			CASE WHEN to_tsvector('english', COALESCE(e.notes, '')) @@ q.query
+				THEN ts_headline('english', e.notes, q.query, $3) ELSE '' END
+		FROM workouts_entries e, q
+		WHERE e.workout_id = ANY($1) AND e.search_vector @@ q.query
+		ORDER BY e.workout_id, e.order_index
+	`
+	entryRows, err := pg.db.Query(entryQuery, ids, query, headlineOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer entryRows.Close()
+
+	for entryRows.Next() {
+		var workoutID, entryID int
+		var exerciseName, notes string
+		err = entryRows.Scan(&workoutID, &entryID, &exerciseName, &notes)
+		if err != nil {
+			return nil, err
+		}
+		result := byID[workoutID]
+		if exerciseName != "" {
+			result.Highlights = append(result.Highlights, SearchHighlight{Field: "exercise_name", EntryID: entryID, Snippet: renderHeadline(exerciseName)})
+		}
+		if notes != "" {
+			result.Highlights = append(result.Highlights, SearchHighlight{Field: "notes", EntryID: entryID, Snippet: renderHeadline(notes)})
+		}
+	}
+	return results, entryRows.Err()
+}
+
+// Field weights used by SearchWorkoutsInMemory, matching the default
+// ts_rank weights of the A, B and C labels in the search_vector columns.
+const (
+	searchWeightA = 1.0
+	searchWeightB = 0.4
+	searchWeightC = 0.2
+)
+
+type searchField struct {
+	name    string
+	entryID int
+	text    string
+	weight  float64
+}
+
+// SearchWorkoutsInMemory is the fallback for stores without Postgres, such as
+// the fakes used in handler tests. It approximates SearchWorkouts without
+// stemming or query operators: a workout, or one of its entries, matches when
+// it contains every word of the query, case-insensitively.
+func SearchWorkoutsInMemory(workouts []*Workout, query string, limit int) []*WorkoutSearchResult {
+	var terms []*regexp.Regexp
+	for _, word := range strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		terms = append(terms, regexp.MustCompile(`(?i)`+regexp.QuoteMeta(word)))
+	}
+	if len(terms) == 0 {
+		return []*WorkoutSearchResult{}
+	}
+
+	results := []*WorkoutSearchResult{}
+	for _, workout := range workouts {
+		result := &WorkoutSearchResult{Workout: workout, Highlights: []SearchHighlight{}}
+		documents := [][]searchField{{
+			{name: "title", text: workout.Title, weight: searchWeightA},
+			{name: "description", text: workout.Description, weight: searchWeightB},
+		}}
+		for _, entry := range workout.Entries {
+			notes := ""
+			if entry.Notes != nil {
+				notes = *entry.Notes
+			}
+			documents = append(documents, []searchField{
+				{name: "exercise_name", entryID: entry.ID, text: entry.ExerciseName, weight: searchWeightA},
+				{name: "notes", entryID: entry.ID, text: notes, weight: searchWeightC},
+			})
+		}
+
+		for _, fields := range documents {
+			rank, ok := rankDocument(fields, terms)
+			if !ok {
+				continue
+			}
+			result.Rank += rank
+			for _, field := range fields {
+				if snippet, ok := highlightField(field.text, terms); ok {
+					result.Highlights = append(result.Highlights, SearchHighlight{Field: field.name, EntryID: field.entryID, Snippet: snippet})
+				}
+			}
+		}
+		if result.Rank > 0 {
+			results = append(results, result)
+		}
+	}
+
+	slices.SortFunc(results, func(a, b *WorkoutSearchResult) int {
+		if c := cmp.Compare(b.Rank, a.Rank); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Workout.ID, a.Workout.ID)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// rankDocument reports whether every term occurs in one of fields and sums
+// the weight of the best field each term occurs in.
+func rankDocument(fields []searchField, terms []*regexp.Regexp) (float64, bool) {
+	var rank float64
+	for _, term := range terms {
+		best := 0.0
+		for _, field := range fields {
+			if field.weight > best && term.MatchString(field.text) {
+				best = field.weight
+			}
+		}
+		if best == 0 {
+			return 0, false
+		}
+		rank += best
+	}
+	return rank, true
+}
+
+func highlightField(text string, terms []*regexp.Regexp) (string, bool) {
+	marked := make([]bool, len(text))
+	found := false
+	for _, term := range terms {
+		for _, loc := range term.FindAllStringIndex(text, -1) {
+			for i := loc[0]; i < loc[1]; i++ {
+				marked[i] = true
+			}
+			found = true
+		}
+	}
+	if !found {
+		return "", false
+	}
+
+	var b strings.Builder
+	start := 0
+	for start < len(text) {
+		end := start
+		for end < len(text) && marked[end] == marked[start] {
+			end++
+		}
+		segment := html.EscapeString(text[start:end])
+		if marked[start] {
+			segment = "<mark>" + segment + "</mark>"
+		}
+		b.WriteString(segment)
+		start = end
+	}
+	return b.String(), true
+}
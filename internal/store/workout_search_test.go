@@ -0,0 +1,39 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestSearchWorkoutsInMemory(t *testing.T) {
+	notes := "keep the <bar> close"
+	workouts := []*Workout{
+		{ID: 1, Title: "Morning run", Description: "Easy squat warm-up"},
+		{ID: 2, Title: "Leg day", Entries: []WorkoutEntry{
+			{ID: 10, ExerciseName: "Back Squat", Notes: &notes},
+			{ID: 11, ExerciseName: "Lunges"},
+		}},
+		{ID: 3, Title: "Upper body"},
+	}
+
+	results := SearchWorkoutsInMemory(workouts, "squat", 10)
+	require.Len(t, results, 2)
+	assert.Equal(t, 2, results[0].Workout.ID, "exercise name outranks description")
+	assert.Equal(t, []SearchHighlight{{Field: "exercise_name", EntryID: 10, Snippet: "Back <mark>Squat</mark>"}}, results[0].Highlights)
+	assert.Equal(t, 1, results[1].Workout.ID)
+	assert.Equal(t, []SearchHighlight{{Field: "description", Snippet: "Easy <mark>squat</mark> warm-up"}}, results[1].Highlights)
+
+	results = SearchWorkoutsInMemory(workouts, "squat bar", 10)
+	require.Len(t, results, 1)
+	assert.Equal(t, "keep the &lt;<mark>bar</mark>&gt; close", results[0].Highlights[1].Snippet)
+
+	assert.Len(t, SearchWorkoutsInMemory(workouts, "squat", 1), 1)
+	assert.Empty(t, SearchWorkoutsInMemory(workouts, "deadlift", 10))
+	assert.Empty(t, SearchWorkoutsInMemory(workouts, "  !! ", 10))
+}
+
+func TestRenderHeadline(t *testing.T) {
+	assert.Equal(t, "<mark>Squat</mark> &amp; press", renderHeadline(headlineStart+"Squat"+headlineStop+" & press"))
+}
@@ -2,15 +2,26 @@ package store
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"time"
 )
 
+// ErrEditConflict is returned by versioned writes when the workout changed
+// since the version the caller read.
+var ErrEditConflict = errors.New("edit conflict")
+
 type Workout struct {
 	ID              int            `json:"id"`
+	UserID          int            `json:"user_id"`
 	Title           string         `json:"title"`
 	Description     string         `json:"description,omitempty"`
 	DurationMinutes int            `json:"duration_minutes"`
 	CaloriesBurned  int            `json:"calories_burned,omitempty"`
 	Entries         []WorkoutEntry `json:"entries,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	Version         int            `json:"version"`
 }
 
 type WorkoutEntry struct {
@@ -36,35 +47,158 @@ func NewPostgresWorkoutStore(db *sql.DB) *PostgresWorkoutStore {
 
 type WorkoutStore interface {
 	CreateWorkout(*Workout) (*Workout, error)
-	GetWorkouts() ([]*Workout, error)
-	GetWorkoutByID(id int64) (*Workout, error)
+	GetWorkouts(userID int, filter WorkoutFilter) ([]*Workout, Metadata, error)
+	GetWorkoutByID(id int64, userID int) (*Workout, error)
 	UpdateWorkout(*Workout) error
-	DeleteWorkout(id int64) error
+	UpdateWorkoutDetails(*Workout) error
+	DeleteWorkout(id int64, userID int, version int) error
+	GetWorkoutOwner(id int64) (int, error)
+	GetWorkoutsWithEntries(userID int) ([]*Workout, error)
+	SearchWorkouts(userID int, query string, limit int) ([]*WorkoutSearchResult, error)
+
+	GetWorkoutEntries(workoutID int64) ([]WorkoutEntry, error)
+	GetWorkoutEntry(workoutID, entryID int64) (*WorkoutEntry, error)
+	CreateWorkoutEntry(*WorkoutEntry) error
+	UpdateWorkoutEntry(*WorkoutEntry) error
+	DeleteWorkoutEntry(workoutID, entryID int64) error
 }
 
-func (pg *PostgresWorkoutStore) GetWorkouts() ([]*Workout, error) {
-	query := `
-		SELECT id, title, description, duration_minutes, calories_burned 
+const (
+	DefaultWorkoutPageSize = 20
+	MaxWorkoutPageSize     = 100
+)
+
+const workoutColumns = `id, user_id, title, COALESCE(description, ''), duration_minutes, COALESCE(calories_burned, 0), created_at, updated_at, version`
+
+func scanWorkout(row rowScanner, workout *Workout) error {
+	return row.Scan(&workout.ID, &workout.UserID, &workout.Title, &workout.Description, &workout.DurationMinutes, &workout.CaloriesBurned, &workout.CreatedAt, &workout.UpdatedAt, &workout.Version)
+}
+
+func scanWorkouts(rows *sql.Rows) ([]*Workout, error) {
+	workouts := []*Workout{}
+	for rows.Next() {
+		workout := &Workout{}
+		err := scanWorkout(rows, workout)
+		if err != nil {
+			return nil, err
+		}
+		workouts = append(workouts, workout)
+	}
+	return workouts, rows.Err()
+}
+
+// GetWorkouts returns one page of the user's workouts matching filter. With
+// a cursor the page starts after the row the cursor points to; otherwise
+// filter.Page is used as a 1-based page number.
+func (pg *PostgresWorkoutStore) GetWorkouts(userID int, filter WorkoutFilter) ([]*Workout, Metadata, error) {
+	if filter.Sort == "" {
+		filter.Sort = DefaultWorkoutSort
+	}
+	sortField, ok := WorkoutSortFields[filter.SortColumn()]
+	if !ok {
+		return nil, Metadata{}, fmt.Errorf("unsupported sort field %q", filter.Sort)
+	}
+	if filter.PageSize <= 0 || filter.PageSize > MaxWorkoutPageSize {
+		filter.PageSize = DefaultWorkoutPageSize
+	}
+	filter.Page = max(filter.Page, 1)
+
+	where, args := filter.whereClause([]any{userID})
+	var total int
+	err := pg.db.QueryRow(`SELECT COUNT(*) FROM workouts WHERE user_id = $1`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	direction, comparison := "ASC", ">"
+	if filter.SortDescending() {
+		direction, comparison = "DESC", "<"
+	}
+	offset := (filter.Page - 1) * filter.PageSize
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor, filter.Sort)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		args = append(args, cursor.Value, cursor.ID)
+		where += fmt.Sprintf(" AND (%s, id) %s ($%d::%s, $%d)", sortField.expr, comparison, len(args)-1, sortField.cast, len(args))
+		offset = 0
+	}
+
+	// fetch one extra row to learn whether there is a next page
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM workouts
+		WHERE user_id = $1%s
+		ORDER BY %s %s, id %s
+		LIMIT %d OFFSET %d
+	`, workoutColumns, where, sortField.expr, direction, direction, filter.PageSize+1, offset)
+	rows, err := pg.db.Query(query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	workouts, err := scanWorkouts(rows)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := newMetadata(total, filter.Page, filter.PageSize, filter.Cursor != "")
+	if len(workouts) > filter.PageSize {
+		workouts = workouts[:filter.PageSize]
+		last := workouts[len(workouts)-1]
+		metadata.NextCursor = encodeCursor(workoutCursor{
+			Sort:  filter.Sort,
+			Value: cursorValue(last, filter.SortColumn()),
+			ID:    last.ID,
+		})
+	}
+	return workouts, metadata, nil
+}
+
+// GetWorkoutsWithEntries returns every workout of the user with its entries
+// loaded, for exports.
+func (pg *PostgresWorkoutStore) GetWorkoutsWithEntries(userID int) ([]*Workout, error) {
+	rows, err := pg.db.Query(`SELECT `+workoutColumns+` FROM workouts WHERE user_id = $1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	workouts, err := scanWorkouts(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*Workout, len(workouts))
+	for _, workout := range workouts {
+		byID[workout.ID] = workout
+	}
+
+	query := `
+		SELECT e.id, e.workout_id, e.exercise_name, e.sets, e.reps, e.duration_second, e.weight, e.notes, e.order_index, e.created_at
+		FROM workouts_entries e
+		INNER JOIN workouts w ON w.id = e.workout_id
+		WHERE w.user_id = $1
+		ORDER BY e.workout_id, e.order_index
 	`
-	rows, err := pg.db.Query(query)
+	rows, err = pg.db.Query(query, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var workouts []*Workout
 	for rows.Next() {
-		workout := &Workout{}
-		err = rows.Scan(&workout.ID, &workout.Title, &workout.Description, &workout.DurationMinutes, &workout.CaloriesBurned)
+		var entry WorkoutEntry
+		err = rows.Scan(&entry.ID, &entry.WorkoutID, &entry.ExerciseName, &entry.Sets, &entry.Reps, &entry.DurationSeconds, &entry.Weight, &entry.Notes, &entry.OrderIndex, &entry.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
-		workouts = append(workouts, workout)
+		if workout, ok := byID[entry.WorkoutID]; ok {
+			workout.Entries = append(workout.Entries, entry)
+		}
 	}
 
-	return workouts, nil
-
+	return workouts, rows.Err()
 }
 
 func (pg *PostgresWorkoutStore) CreateWorkout(workout *Workout) (*Workout, error) {
@@ -76,34 +210,20 @@ func (pg *PostgresWorkoutStore) CreateWorkout(workout *Workout) (*Workout, error
 
 	// Insert workout
 	query := `
-        INSERT INTO workouts (title, description, duration_minutes, calories_burned)
-        VALUES ($1, $2, $3, $4)
-        RETURNING id
+        INSERT INTO workouts (user_id, title, description, duration_minutes, calories_burned)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at, updated_at, version
     `
-	err = tx.QueryRow(query, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned).Scan(&workout.ID)
+	err = tx.QueryRow(query, workout.UserID, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned).Scan(&workout.ID, &workout.CreatedAt, &workout.UpdatedAt, &workout.Version)
 	if err != nil {
 		return nil, err
 	}
 
 	// Insert workout entries
+	normalizeEntryOrder(workout.Entries)
 	for i := range workout.Entries {
-		query := `
-            INSERT INTO workouts_entries 
-                (workout_id, exercise_name, sets, reps, duration_second, weight, notes, order_index)
-            VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
-            RETURNING id
-        `
-		err = tx.QueryRow(
-			query,
-			workout.ID,
-			workout.Entries[i].ExerciseName,
-			workout.Entries[i].Sets,
-			workout.Entries[i].Reps,
-			workout.Entries[i].DurationSeconds,
-			workout.Entries[i].Weight,
-			workout.Entries[i].Notes,
-			workout.Entries[i].OrderIndex,
-		).Scan(&workout.Entries[i].ID)
+		workout.Entries[i].WorkoutID = workout.ID
+		err = insertWorkoutEntry(tx, &workout.Entries[i])
 		if err != nil {
 			return nil, err
 		}
@@ -118,13 +238,15 @@ func (pg *PostgresWorkoutStore) CreateWorkout(workout *Workout) (*Workout, error
 	return workout, nil
 }
 
-func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64) (*Workout, error) {
+// GetWorkoutByID returns the workout with its entries, or nil when the user
+// has no workout with that id.
+func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64, userID int) (*Workout, error) {
 	workout := &Workout{}
 	query := `
-		SELECT id, title, description, duration_minutes, calories_burned 
-		FROM workouts WHERE id = $1
+		SELECT ` + workoutColumns + `
+		FROM workouts WHERE id = $1 AND user_id = $2
 	`
-	err := pg.db.QueryRow(query, id).Scan(&workout.ID, &workout.Title, &workout.Description, &workout.DurationMinutes, &workout.CaloriesBurned)
+	err := scanWorkout(pg.db.QueryRow(query, id, userID), workout)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -132,94 +254,91 @@ func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64) (*Workout, error) {
 		return nil, err
 	}
 
-	entryQuery := `
-		SELECT id, exercise_name, sets, reps, duration_second, weight, notes, order_index 
-		FROM workouts_entries WHERE workout_id = $1 ORDER BY order_index
-	`
-	rows, err := pg.db.Query(entryQuery, id)
+	workout.Entries, err = pg.GetWorkoutEntries(id)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var entry WorkoutEntry
-		err = rows.Scan(&entry.ID, &entry.ExerciseName, &entry.Sets, &entry.Reps, &entry.DurationSeconds, &entry.Weight, &entry.Notes, &entry.OrderIndex)
-		if err != nil {
-			return nil, err
-		}
-		workout.Entries = append(workout.Entries, entry)
-	}
 
 	return workout, nil
 }
 
-func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout) error {
-	tx, err := pg.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+// UpdateWorkoutDetails updates the columns of the workout itself and leaves
+// its entries untouched. Like UpdateWorkout it only applies to
+// workout.Version when that is set.
+func (pg *PostgresWorkoutStore) UpdateWorkoutDetails(workout *Workout) error {
+	return updateWorkoutRow(pg.db, workout)
+}
 
-	// Update workout table
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func updateWorkoutRow(q queryRower, workout *Workout) error {
 	query := `UPDATE workouts 
-			  SET title=$1, description=$2, duration_minutes=$3, calories_burned=$4 
-			  WHERE id=$5`
+			  SET title=$1, description=$2, duration_minutes=$3, calories_burned=$4, updated_at=CURRENT_TIMESTAMP, version=version+1
+			  WHERE id=$5 AND user_id=$6 AND ($7 = 0 OR version = $7)
+			  RETURNING updated_at, version`
 
-	result, err := tx.Exec(query,
+	err := q.QueryRow(query,
 		workout.Title,
 		workout.Description,
 		workout.DurationMinutes,
 		workout.CaloriesBurned,
 		workout.ID,
-	)
-	if err != nil {
-		return err
+		workout.UserID,
+		workout.Version,
+	).Scan(&workout.UpdatedAt, &workout.Version)
+	if err == sql.ErrNoRows && workout.Version != 0 {
+		return conflictOrNotFound(q, int64(workout.ID), workout.UserID)
 	}
+	return err
+}
 
-	rowsAffected, err := result.RowsAffected()
+// conflictOrNotFound explains why a versioned write to the workout matched
+// no row.
+func conflictOrNotFound(q queryRower, workoutID int64, userID int) error {
+	var exists bool
+	err := q.QueryRow(`SELECT EXISTS(SELECT 1 FROM workouts WHERE id = $1 AND user_id = $2)`, workoutID, userID).Scan(&exists)
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	if exists {
+		return ErrEditConflict
 	}
+	return sql.ErrNoRows
+}
 
-	// Reset entries
-	_, err = tx.Exec(`DELETE FROM workouts_entries WHERE workout_id=$1`, workout.ID)
+// UpdateWorkout updates the workout and syncs its entries. It returns
+// sql.ErrNoRows unless the workout belongs to workout.UserID. When
+// workout.Version is set the update only applies if the stored version still
+// matches, and fails with ErrEditConflict otherwise.
+func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout) error {
+	tx, err := pg.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// Insert updated entries
-	for _, entry := range workout.Entries {
-
-		query := `INSERT INTO workouts_entries
-			(workout_id, exercise_name, sets, reps, duration_second, weight, notes, order_index)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`
+	// Update workout table
+	err = updateWorkoutRow(tx, workout)
+	if err != nil {
+		return err
+	}
 
-		_, err = tx.Exec(query,
-			workout.ID,
-			entry.ExerciseName,
-			entry.Sets,
-			entry.Reps,
-			entry.DurationSeconds,
-			entry.Weight,
-			entry.Notes,
-			entry.OrderIndex,
-		)
-		if err != nil {
-			return err
-		}
+	err = syncWorkoutEntries(tx, workout)
+	if err != nil {
+		return err
 	}
 
 	return tx.Commit()
 }
 
-func (pg *PostgresWorkoutStore) DeleteWorkout(id int64) error {
-	query := `DELETE FROM workouts WHERE id = $1`
+// DeleteWorkout deletes the user's workout. A non-zero version makes the
+// delete conditional in the same way as UpdateWorkout.
+func (pg *PostgresWorkoutStore) DeleteWorkout(id int64, userID int, version int) error {
+	query := `DELETE FROM workouts WHERE id = $1 AND user_id = $2 AND ($3 = 0 OR version = $3)`
 
-	result, err := pg.db.Exec(query, id)
+	result, err := pg.db.Exec(query, id, userID, version)
 	if err != nil {
 		return err
 	}
@@ -228,7 +347,20 @@ func (pg *PostgresWorkoutStore) DeleteWorkout(id int64) error {
 		return err
 	}
 	if rowsAffected == 0 {
+		if version != 0 {
+			return conflictOrNotFound(pg.db, id, userID)
+		}
 		return sql.ErrNoRows
 	}
 	return nil
 }
+
+func (pg *PostgresWorkoutStore) GetWorkoutOwner(id int64) (int, error) {
+	var userID int
+	query := `SELECT user_id FROM workouts WHERE id = $1`
+	err := pg.db.QueryRow(query, id).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
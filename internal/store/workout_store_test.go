@@ -17,7 +17,7 @@ func setupTestDB(t *testing.T) *sql.DB {
 	if err != nil {
 		t.Fatalf("failed to migrate database: %v", err)
 	}
-	_, err = db.Exec(`TRUNCATE workouts, workouts_entries CASCADE`)
+	_, err = db.Exec(`TRUNCATE users, workouts, workouts_entries CASCADE`)
 	if err != nil {
 		t.Fatalf("failed to truncate tables: %v", err)
 	}
@@ -28,6 +28,7 @@ func TestCreateWorkout(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 	store := NewPostgresWorkoutStore(db)
+	owner := createTestUser(t, db)
 
 	tests := []struct {
 		name        string
@@ -37,6 +38,7 @@ func TestCreateWorkout(t *testing.T) {
 		{
 			name: "valid workout",
 			input: &Workout{
+				UserID:          owner.ID,
 				Title:           "Morning Routine",
 				Description:     "A quick morning workout",
 				DurationMinutes: 30,
@@ -63,6 +65,7 @@ func TestCreateWorkout(t *testing.T) {
 		{
 			name: "workout with invalid entries",
 			input: &Workout{
+				UserID:          owner.ID,
 				Title:           "full body",
 				Description:     "compete workout",
 				DurationMinutes: 90,
@@ -103,10 +106,11 @@ func TestCreateWorkout(t *testing.T) {
 			assert.Equal(t, tt.input.DurationMinutes, createWorkout.DurationMinutes)
 			assert.Equal(t, tt.input.CaloriesBurned, createWorkout.CaloriesBurned)
 
-			retrieved, err := store.GetWorkoutByID(int64(createWorkout.ID))
+			retrieved, err := store.GetWorkoutByID(int64(createWorkout.ID), owner.ID)
 
 			require.NoError(t, err)
 			assert.Equal(t, createWorkout.ID, retrieved.ID)
+			assert.Equal(t, owner.ID, retrieved.UserID)
 			assert.Equal(t, len(tt.input.Entries), len(retrieved.Entries))
 			for i := range retrieved.Entries {
 				assert.Equal(t, tt.input.Entries[i].ExerciseName, retrieved.Entries[i].ExerciseName)
@@ -117,6 +121,118 @@ func TestCreateWorkout(t *testing.T) {
 	}
 }
 
+func TestWorkoutEntries(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	store := NewPostgresWorkoutStore(db)
+	owner := createTestUser(t, db)
+
+	workout, err := store.CreateWorkout(&Workout{
+		UserID:          owner.ID,
+		Title:           "Leg day",
+		DurationMinutes: 45,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "Squat", Sets: 5},
+			{ExerciseName: "Lunge", Sets: 3},
+		},
+	})
+	require.NoError(t, err)
+	workoutID := int64(workout.ID)
+	squat, lunge := workout.Entries[0], workout.Entries[1]
+
+	names := func() []string {
+		entries, err := store.GetWorkoutEntries(workoutID)
+		require.NoError(t, err)
+		var names []string
+		for i, entry := range entries {
+			assert.Equal(t, i+1, entry.OrderIndex)
+			names = append(names, entry.ExerciseName)
+		}
+		return names
+	}
+
+	plank := &WorkoutEntry{WorkoutID: workout.ID, ExerciseName: "Plank", Sets: 2, OrderIndex: 1}
+	require.NoError(t, store.CreateWorkoutEntry(plank))
+	assert.Equal(t, []string{"Plank", "Squat", "Lunge"}, names())
+
+	plank.OrderIndex = 10
+	require.NoError(t, store.UpdateWorkoutEntry(plank))
+	assert.Equal(t, 3, plank.OrderIndex)
+	assert.Equal(t, []string{"Squat", "Lunge", "Plank"}, names())
+
+	require.NoError(t, store.DeleteWorkoutEntry(workoutID, int64(squat.ID)))
+	assert.Equal(t, []string{"Lunge", "Plank"}, names())
+	assert.ErrorIs(t, store.DeleteWorkoutEntry(workoutID, int64(squat.ID)), sql.ErrNoRows)
+
+	// a full update keeps the ids of entries it still contains
+	lunge.Sets = 4
+	workout.Entries = []WorkoutEntry{lunge, {ExerciseName: "Deadlift", Sets: 3}}
+	require.NoError(t, store.UpdateWorkout(workout))
+	entries, err := store.GetWorkoutEntries(workoutID)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, lunge.ID, entries[0].ID)
+	assert.Equal(t, 4, entries[0].Sets)
+	assert.Equal(t, "Deadlift", entries[1].ExerciseName)
+
+	workout.Entries = []WorkoutEntry{{ID: plank.ID, ExerciseName: "Plank", Sets: 2}}
+	assert.ErrorIs(t, store.UpdateWorkout(workout), ErrWorkoutEntryNotFound)
+}
+
+func TestWorkoutVersion(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	store := NewPostgresWorkoutStore(db)
+	owner := createTestUser(t, db)
+
+	workout, err := store.CreateWorkout(&Workout{UserID: owner.ID, Title: "Leg day", DurationMinutes: 45})
+	require.NoError(t, err)
+	assert.Equal(t, 1, workout.Version)
+
+	workout.Title = "Legs"
+	require.NoError(t, store.UpdateWorkoutDetails(workout))
+	assert.Equal(t, 2, workout.Version)
+
+	stale := *workout
+	stale.Version = 1
+	assert.ErrorIs(t, store.UpdateWorkout(&stale), ErrEditConflict)
+
+	require.NoError(t, store.CreateWorkoutEntry(&WorkoutEntry{WorkoutID: workout.ID, ExerciseName: "Squat", Sets: 5}))
+	retrieved, err := store.GetWorkoutByID(int64(workout.ID), owner.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, retrieved.Version, "entry changes bump the workout version")
+
+	assert.ErrorIs(t, store.DeleteWorkout(int64(workout.ID), owner.ID, 2), ErrEditConflict)
+	require.NoError(t, store.DeleteWorkout(int64(workout.ID), owner.ID, 3))
+	assert.ErrorIs(t, store.DeleteWorkout(int64(workout.ID), owner.ID, 3), sql.ErrNoRows)
+}
+
+func TestNormalizeEntryOrder(t *testing.T) {
+	entries := []WorkoutEntry{
+		{ExerciseName: "c", OrderIndex: 30},
+		{ExerciseName: "appended"},
+		{ExerciseName: "a", OrderIndex: 5},
+		{ExerciseName: "b", OrderIndex: 5},
+	}
+	normalizeEntryOrder(entries)
+
+	var got []string
+	for i, entry := range entries {
+		assert.Equal(t, i+1, entry.OrderIndex)
+		got = append(got, entry.ExerciseName)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "appended"}, got)
+}
+
+func createTestUser(t *testing.T, db *sql.DB) *User {
+	user := &User{Username: "tester", Email: "tester@example.com"}
+	err := user.PasswordHash.Set("Passw0rd!")
+	require.NoError(t, err)
+	user, err = NewPostgresUserStore(db).CreateUser(user)
+	require.NoError(t, err)
+	return user
+}
+
 func IntPtr(i int) *int {
 	return &i
 }
@@ -4,11 +4,24 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"strings"
 	"time"
 )
 
 const (
-	ScopeAuth = "authentication"
+	ScopeAuth          = "authentication"
+	ScopeRefresh       = "refresh"
+	ScopePasswordReset = "password-reset"
+	ScopeActivation    = "activation"
+	ScopeMFAPending    = "mfa-pending"
+)
+
+const (
+	AccessTokenTTL        = 15 * time.Minute
+	RefreshTokenTTL       = 30 * 24 * time.Hour
+	PasswordResetTokenTTL = 30 * time.Minute
+	ActivationTokenTTL    = 3 * 24 * time.Hour
+	MFAPendingTokenTTL    = 5 * time.Minute
 )
 
 type Token struct {
@@ -17,6 +30,15 @@ type Token struct {
 	UserID    int       `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	Family    string    `json:"-"`
+	Client    Client    `json:"-"`
+}
+
+// Client describes where a token was issued, so users can recognise their
+// sessions.
+type Client struct {
+	UserAgent string
+	IP        string
 }
 
 func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error) {
@@ -37,3 +59,40 @@ func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error)
 	return token, nil
 
 }
+
+// NewFamily returns a random identifier shared by every token issued from a
+// single login, so that a whole refresh chain can be revoked at once.
+func NewFamily() (string, error) {
+	emptyBytes := make([]byte, 16)
+	_, err := rand.Read(emptyBytes)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes), nil
+}
+
+// API keys are long-lived credentials for scripts. The prefix lets the auth
+// middleware tell them apart from session tokens.
+const APIKeyPrefix = "fem_"
+
+const (
+	APIScopeWorkoutsRead  = "workouts:read"
+	APIScopeWorkoutsWrite = "workouts:write"
+)
+
+var APIScopes = []string{APIScopeWorkoutsRead, APIScopeWorkoutsWrite}
+
+func GenerateAPIKey() (string, []byte, error) {
+	emptyBytes := make([]byte, 32)
+	_, err := rand.Read(emptyBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	plaintext := APIKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes))
+	hash := sha256.Sum256([]byte(plaintext))
+	return plaintext, hash[:], nil
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
@@ -0,0 +1,31 @@
+package totp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"strings"
+)
+
+const RecoveryCodeCount = 10
+
+// GenerateRecoveryCodes returns n single-use codes formatted as
+// "xxxxx-xxxxx" for users who lose their authenticator.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		raw := make([]byte, 7)
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(raw))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+func HashRecoveryCode(code string) []byte {
+	normalized := strings.ToLower(strings.TrimSpace(code))
+	hash := sha256.Sum256([]byte(normalized))
+	return hash[:]
+}
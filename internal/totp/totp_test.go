@@ -0,0 +1,71 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+// Test vectors for HMAC-SHA1 from RFC 6238, appendix B.
+func TestHOTPRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "94287082"},
+		{unix: 1111111109, expected: "07081804"},
+		{unix: 1111111111, expected: "14050471"},
+		{unix: 1234567890, expected: "89005924"},
+		{unix: 2000000000, expected: "69279037"},
+		{unix: 20000000000, expected: "65353130"},
+	}
+
+	for _, tt := range tests {
+		step := Step(time.Unix(tt.unix, 0))
+		assert.Equal(t, tt.expected, hotp(key, uint64(step), 8))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(59, 0)
+
+	code, err := GenerateCode(secret, now)
+	require.NoError(t, err)
+	assert.Equal(t, "287082", code)
+
+	step, ok := Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	_, ok = Validate(secret, code, now.Add(Period))
+	assert.True(t, ok, "previous period is accepted")
+
+	_, ok = Validate(secret, code, now.Add(3*Period))
+	assert.False(t, ok, "stale code is rejected")
+
+	_, ok = Validate(secret, "000000", now)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Fem Project", "alice@example.com", "ABC")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Fem%20Project:alice@example.com?"))
+	assert.Contains(t, uri, "secret=ABC")
+	assert.Contains(t, uri, "issuer=Fem+Project")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	require.NoError(t, err)
+	assert.Len(t, codes, RecoveryCodeCount)
+	for _, code := range codes {
+		assert.Len(t, code, 11)
+		assert.Equal(t, HashRecoveryCode(code), HashRecoveryCode(" "+strings.ToUpper(code)))
+	}
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MergePatch applies the RFC 7396 JSON merge patch to the JSON document
+// target and returns the merged document. Members set to null in the patch
+// are removed from the target and arrays are replaced as a whole.
+func MergePatch(target, patch []byte) ([]byte, error) {
+	var t, p any
+	err := decodeNumbers(target, &t)
+	if err != nil {
+		return nil, err
+	}
+	err = decodeNumbers(patch, &p)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeValue(t, p))
+}
+
+func decodeNumbers(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func mergeValue(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergeValue(targetObject[key], value)
+	}
+	return targetObject
+}
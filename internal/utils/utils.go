@@ -3,6 +3,7 @@ package utils
 import (
 	"encoding/json"
 	"errors"
+	"net"
 	"net/http"
 	"strconv"
 
@@ -38,3 +39,12 @@ func ReadIDParams(r *http.Request) (int64, error) {
 func ParseInt64(s string) (int64, error) {
 	return strconv.ParseInt(s, 10, 64)
 }
+
+// ClientIP returns the host part of the request's remote address.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
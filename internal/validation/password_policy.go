@@ -0,0 +1,115 @@
+package validation
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+//go:embed banned_passwords.txt
+var defaultBannedPasswords string
+
+// PasswordPolicy describes the rules a new password must satisfy. The zero
+// value accepts anything; use DefaultPasswordPolicy for the app's rules.
+type PasswordPolicy struct {
+	MinLength      int
+	MaxLength      int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireNumber  bool
+	RequireSpecial bool
+	// HistorySize is how many previous passwords, including the current
+	// one, may not be reused. Checking it needs the stored hashes, so it
+	// is enforced by the caller rather than by Validate.
+	HistorySize int
+	banned      map[string]struct{}
+}
+
+func DefaultPasswordPolicy() *PasswordPolicy {
+	p := &PasswordPolicy{
+		MinLength:      8,
+		MaxLength:      128,
+		RequireUpper:   true,
+		RequireLower:   true,
+		RequireNumber:  true,
+		RequireSpecial: true,
+		HistorySize:    5,
+	}
+	p.AddBanned(strings.NewReader(defaultBannedPasswords))
+	return p
+}
+
+// AddBanned reads one password per line, ignoring blank lines and lines
+// starting with #. Matching is case-insensitive.
+func (p *PasswordPolicy) AddBanned(r io.Reader) error {
+	if p.banned == nil {
+		p.banned = make(map[string]struct{})
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.banned[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+func (p *PasswordPolicy) LoadBannedFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.AddBanned(f)
+}
+
+// Validate returns one message per rule the password breaks, or nil.
+func (p *PasswordPolicy) Validate(password string) []string {
+	var failures []string
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		failures = append(failures, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		failures = append(failures, fmt.Sprintf("must be at most %d characters long", p.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasNumber, hasSpecial bool
+	for _, char := range password {
+		switch {
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsDigit(char):
+			hasNumber = true
+		case unicode.IsPunct(char) || unicode.IsSymbol(char):
+			hasSpecial = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		failures = append(failures, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		failures = append(failures, "must contain a lowercase letter")
+	}
+	if p.RequireNumber && !hasNumber {
+		failures = append(failures, "must contain a number")
+	}
+	if p.RequireSpecial && !hasSpecial {
+		failures = append(failures, "must contain a special character")
+	}
+
+	if _, ok := p.banned[strings.ToLower(password)]; ok {
+		failures = append(failures, "is too common, choose a less predictable password")
+	}
+
+	return failures
+}
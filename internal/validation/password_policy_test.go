@@ -0,0 +1,45 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/assert"
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+
+	tests := []struct {
+		name     string
+		password string
+		expected []string
+	}{
+		{name: "valid", password: "Corr3ct-Horse", expected: nil},
+		{name: "unicode classes", password: "Ünïcødé-Pässw0rd", expected: nil},
+		{name: "too short", password: "Ab1!", expected: []string{"must be at least 8 characters long"}},
+		{
+			name:     "missing classes",
+			password: "alllowercase",
+			expected: []string{"must contain an uppercase letter", "must contain a number", "must contain a special character"},
+		},
+		{name: "banned", password: "p@ssw0rd1", expected: []string{"must contain an uppercase letter", "is too common, choose a less predictable password"}},
+		{name: "banned case-insensitive", password: "PASSW0RD!", expected: []string{"must contain a lowercase letter", "is too common, choose a less predictable password"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, policy.Validate(tt.password))
+		})
+	}
+}
+
+func TestPasswordPolicyAddBanned(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 1}
+	err := policy.AddBanned(strings.NewReader("# comment\n\n  Hunter2  \n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"is too common, choose a less predictable password"}, policy.Validate("hunter2"))
+	assert.Nil(t, policy.Validate("# comment"))
+}
@@ -0,0 +1,24 @@
+package validation
+
+import (
+	"strings"
+	"time"
+	_ "time/tzdata" // timezone validation must not depend on the host's zoneinfo
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// IsTimezoneValid reports whether tz is an IANA time zone name such as
+// "Europe/Berlin" or "UTC".
+func IsTimezoneValid(tz string) bool {
+	if tz == "" || tz == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(tz)
+	return err == nil
+}
+
+// NormalizeDisplayName trims surrounding space and applies Unicode NFKC.
+func NormalizeDisplayName(name string) string {
+	return norm.NFKC.String(strings.TrimSpace(name))
+}
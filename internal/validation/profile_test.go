@@ -0,0 +1,15 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/go-openapi/testify/v2/assert"
+)
+
+func TestIsTimezoneValid(t *testing.T) {
+	assert.True(t, IsTimezoneValid("UTC"))
+	assert.True(t, IsTimezoneValid("Europe/Berlin"))
+	assert.False(t, IsTimezoneValid(""))
+	assert.False(t, IsTimezoneValid("Local"))
+	assert.False(t, IsTimezoneValid("Mars/Olympus_Mons"))
+}
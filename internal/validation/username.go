@@ -0,0 +1,65 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	UsernameMinLength = 3
+	UsernameMaxLength = 50
+)
+
+// reservedUsernames cannot be registered because they collide with routes
+// or could be mistaken for staff accounts. Matching is case-insensitive.
+var reservedUsernames = map[string]struct{}{
+	"admin": {}, "administrator": {}, "api": {}, "availability": {}, "help": {},
+	"me": {}, "mod": {}, "moderator": {}, "null": {}, "root": {}, "security": {},
+	"staff": {}, "support": {}, "system": {}, "undefined": {}, "fem": {}, "femproject": {},
+}
+
+// NormalizeUsername trims surrounding space and applies Unicode NFKC so that
+// visually identical names (full-width letters, ligatures, combining marks)
+// are stored the same way. Case is preserved; uniqueness is case-insensitive.
+func NormalizeUsername(username string) string {
+	return norm.NFKC.String(strings.TrimSpace(username))
+}
+
+// NormalizeEmail trims surrounding space and applies Unicode NFKC. Case is
+// preserved; uniqueness is case-insensitive.
+func NormalizeEmail(email string) string {
+	return norm.NFKC.String(strings.TrimSpace(email))
+}
+
+// ValidateUsername checks an already normalized username. Letters and digits
+// from any script are allowed, plus '.', '_' and '-' between them.
+func ValidateUsername(username string) error {
+	length := utf8.RuneCountInString(username)
+	if length < UsernameMinLength || length > UsernameMaxLength {
+		return errors.New("username must be between 3 and 50 characters long")
+	}
+	for i, char := range username {
+		switch {
+		case unicode.IsLetter(char), unicode.IsDigit(char):
+		case char == '.' || char == '_' || char == '-':
+			if i == 0 || i == len(username)-1 {
+				return errors.New("username must start and end with a letter or digit")
+			}
+		default:
+			return errors.New("username may only contain letters, digits, '.', '_' and '-'")
+		}
+	}
+	if IsUsernameReserved(username) {
+		return errors.New("username is reserved")
+	}
+	return nil
+}
+
+func IsUsernameReserved(username string) bool {
+	_, ok := reservedUsernames[strings.ToLower(username)]
+	return ok
+}
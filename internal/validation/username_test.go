@@ -0,0 +1,44 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/go-openapi/testify/v2/assert"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	assert.Equal(t, "alice", NormalizeUsername("  alice "))
+	assert.Equal(t, "Alice", NormalizeUsername("Ａｌｉｃｅ"), "full-width letters fold to ASCII")
+	assert.Equal(t, "José", NormalizeUsername("José"), "combining accent is composed")
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		expected string
+	}{
+		{name: "ascii", username: "alice_99", expected: ""},
+		{name: "unicode letters", username: "Jürgen", expected: ""},
+		{name: "inner punctuation", username: "a.b-c", expected: ""},
+		{name: "too short", username: "al", expected: "username must be between 3 and 50 characters long"},
+		{name: "leading dot", username: ".alice", expected: "username must start and end with a letter or digit"},
+		{name: "trailing dash", username: "alice-", expected: "username must start and end with a letter or digit"},
+		{name: "space", username: "al ice", expected: "username may only contain letters, digits, '.', '_' and '-'"},
+		{name: "emoji", username: "ali💪ce", expected: "username may only contain letters, digits, '.', '_' and '-'"},
+		{name: "reserved", username: "Admin", expected: "username is reserved"},
+		{name: "short route name", username: "me", expected: "username must be between 3 and 50 characters long"},
+		{name: "reserved route name", username: "availability", expected: "username is reserved"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUsername(tt.username)
+			if tt.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+}
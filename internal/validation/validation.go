@@ -6,37 +6,20 @@ type Validator struct {
 	Errors map[string][]string
 }
 
-func IsEmailValid(email string) bool {
-	emailRegex := `^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`
-	re := regexp.MustCompile(emailRegex)
-	return re.MatchString(email)
+func New() *Validator {
+	return &Validator{Errors: make(map[string][]string)}
 }
 
-func IsPasswordValid(password string) bool {
-	var (
-		hasMinLen  = false
-		hasUpper   = false
-		hasLower   = false
-		hasNumber  = false
-		hasSpecial = false
-	)
-
-	if len(password) >= 8 {
-		hasMinLen = true
-	}
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
 
-	for _, char := range password {
-		switch {
-		case 'A' <= char && char <= 'Z':
-			hasUpper = true
-		case 'a' <= char && char <= 'z':
-			hasLower = true
-		case '0' <= char && char <= '9':
-			hasNumber = true
-		case regexp.MustCompile(`[!@#\$%\^&\*\(\)_\+\-=\[\]\{\};':"\\|,.<>\/?]`).MatchString(string(char)):
-			hasSpecial = true
-		}
-	}
+func (v *Validator) AddError(field, message string) {
+	v.Errors[field] = append(v.Errors[field], message)
+}
 
-	return hasMinLen && hasUpper && hasLower && hasNumber && hasSpecial
+func IsEmailValid(email string) bool {
+	emailRegex := `^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`
+	re := regexp.MustCompile(emailRegex)
+	return re.MatchString(email)
 }
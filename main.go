@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/alireza-akbarzadeh/fem_project/internal/app"
@@ -31,6 +36,11 @@ import (
 //  @host       localhost:8080
 //  @BasePath   /api/v1
 //  @schemes    http https
+//
+//  @securityDefinitions.apikey  BearerAuth
+//  @in                          header
+//  @name                        Authorization
+//  @description                 Type "Bearer" followed by a space and the token returned from POST /tokens.
 
 func main() {
 	var port int
@@ -54,8 +64,26 @@ func main() {
 
 	app.Logger.Printf(constants.Blue+"🚀 Server is running! Open in your browser: http://localhost:%d\n"+constants.Reset, port)
 
+	shutdownComplete := make(chan struct{})
+	go func() {
+		defer close(shutdownComplete)
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+
+		app.Logger.Printf(constants.Yellow + "🛑 Shutting down server..." + constants.Reset)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err != nil {
+			app.Logger.Printf(constants.Red+"❌ Graceful shutdown failed: %v"+constants.Reset, err)
+		}
+	}()
+
 	err = server.ListenAndServe()
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		app.Logger.Fatalf(constants.Red+"❌ Server failed: %v"+constants.Reset, err)
 	}
+	<-shutdownComplete
+	app.Jobs.Stop()
 }
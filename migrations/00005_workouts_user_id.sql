@@ -0,0 +1,32 @@
+-- +goose Up 
+-- +goose StatementBegin
+ALTER TABLE workouts
+ADD COLUMN user_id BIGINT REFERENCES users(id) ON DELETE CASCADE;
+
+-- workouts created before they had an owner cannot be attributed to anyone,
+-- and handing them to a real account would expose them to that user. They
+-- go to a dedicated owner instead: its placeholder address under the
+-- reserved .invalid domain receives no mail and the hash is of a random
+-- password that was never kept, so nobody can sign in as it. An operator
+-- can move the workouts to their real owners or delete the account, which
+-- deletes the workouts with it.
+INSERT INTO users (username, email, password_hash)
+SELECT 'orphaned-workouts', 'orphaned-workouts@email.invalid',
+       '$2a$12$DrJ6jxqWMQ12GfTHcCPJ8OFws3zz.Vne0XRAozxKPFFDZj78JLj3a'
+WHERE EXISTS (SELECT 1 FROM workouts WHERE user_id IS NULL);
+
+UPDATE workouts
+SET user_id = (SELECT id FROM users WHERE email = 'orphaned-workouts@email.invalid')
+WHERE user_id IS NULL;
+
+ALTER TABLE workouts
+ALTER COLUMN user_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_workouts_user_id ON workouts(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_workouts_user_id;
+ALTER TABLE workouts DROP COLUMN user_id;
+-- +goose StatementEnd
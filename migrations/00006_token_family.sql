@@ -0,0 +1,14 @@
+-- +goose Up 
+-- +goose StatementBegin
+ALTER TABLE token
+ADD COLUMN family TEXT NOT NULL DEFAULT '',
+ADD COLUMN used_at TIMESTAMP(0) WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_token_family ON token(family);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_token_family;
+ALTER TABLE token DROP COLUMN used_at, DROP COLUMN family;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up 
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN activated BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- accounts created before email verification existed stay usable
+UPDATE users SET activated = TRUE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN activated;
+-- +goose StatementEnd
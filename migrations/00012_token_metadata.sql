@@ -0,0 +1,22 @@
+-- +goose Up 
+-- +goose StatementBegin
+ALTER TABLE token
+ADD COLUMN id BIGSERIAL UNIQUE,
+ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+ADD COLUMN last_used_at TIMESTAMPTZ,
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_token_user_id_scope ON token(user_id, scope);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_token_user_id_scope;
+ALTER TABLE token
+DROP COLUMN ip,
+DROP COLUMN user_agent,
+DROP COLUMN last_used_at,
+DROP COLUMN created_at,
+DROP COLUMN id;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up 
+-- +goose StatementBegin
+INSERT INTO permissions (code) VALUES ('metrics:read');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'admin' AND p.code = 'metrics:read';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE code = 'metrics:read';
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up 
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN deletion_scheduled_for TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_for ON users(deletion_scheduled_for)
+WHERE deletion_scheduled_for IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_users_deletion_scheduled_for;
+ALTER TABLE users
+DROP COLUMN deletion_scheduled_for;
+-- +goose StatementEnd
@@ -0,0 +1,39 @@
+-- +goose Up 
+-- +goose StatementBegin
+-- give accounts without a username, or with a name that clashes with an
+-- older account when compared case-insensitively, a unique fallback name.
+-- A bare 'user' || id could match a name someone already picked, such as
+-- user42, so the id is followed by part of a hash of the account
+UPDATE users u
+SET username = 'user_' || u.id || '_' || SUBSTR(MD5(u.id || ':' || u.email), 1, 6)
+WHERE u.username IS NULL
+   OR u.username = ''
+   OR EXISTS (
+      SELECT 1 FROM users o
+      WHERE LOWER(o.username) = LOWER(u.username) AND o.id < u.id
+   );
+
+-- likewise an email that clashes with an older account gets a placeholder
+-- under the reserved .invalid domain, and the account is deactivated until
+-- an admin restores a real address
+UPDATE users u
+SET email = 'duplicate-' || u.id || '@email.invalid', activated = FALSE
+WHERE EXISTS (
+   SELECT 1 FROM users o
+   WHERE LOWER(o.email) = LOWER(u.email) AND o.id < u.id
+);
+
+ALTER TABLE users
+ALTER COLUMN username SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_key ON users (LOWER(username));
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (LOWER(email));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_email_lower_key;
+DROP INDEX IF EXISTS users_username_lower_key;
+ALTER TABLE users
+ALTER COLUMN username DROP NOT NULL;
+-- +goose StatementEnd
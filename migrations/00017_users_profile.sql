@@ -0,0 +1,32 @@
+-- +goose Up 
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN display_name VARCHAR(100) NOT NULL DEFAULT '',
+ADD COLUMN date_of_birth DATE,
+ADD COLUMN weight_unit VARCHAR(2) NOT NULL DEFAULT 'kg' CHECK (weight_unit IN ('kg', 'lb')),
+ADD COLUMN distance_unit VARCHAR(2) NOT NULL DEFAULT 'km' CHECK (distance_unit IN ('km', 'mi')),
+ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+ADD COLUMN height_cm NUMERIC(4,1),
+ADD COLUMN avatar_key TEXT NOT NULL DEFAULT '';
+
+UPDATE users SET bio = '' WHERE bio IS NULL;
+ALTER TABLE users
+ALTER COLUMN bio SET DEFAULT '',
+ALTER COLUMN bio SET NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+ALTER COLUMN bio DROP NOT NULL,
+ALTER COLUMN bio DROP DEFAULT;
+
+ALTER TABLE users
+DROP COLUMN avatar_key,
+DROP COLUMN height_cm,
+DROP COLUMN timezone,
+DROP COLUMN distance_unit,
+DROP COLUMN weight_unit,
+DROP COLUMN date_of_birth,
+DROP COLUMN display_name;
+-- +goose StatementEnd
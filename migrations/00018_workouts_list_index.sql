@@ -0,0 +1,9 @@
+-- +goose Up 
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS idx_workouts_user_id_created_at ON workouts(user_id, created_at, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_workouts_user_id_created_at;
+-- +goose StatementEnd
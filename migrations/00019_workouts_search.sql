@@ -0,0 +1,25 @@
+-- +goose Up 
+-- +goose StatementBegin
+ALTER TABLE workouts
+ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+  setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+  setweight(to_tsvector('english', coalesce(description, '')), 'B')
+) STORED;
+
+ALTER TABLE workouts_entries
+ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+  setweight(to_tsvector('english', coalesce(exercise_name, '')), 'A') ||
+  setweight(to_tsvector('english', coalesce(notes, '')), 'C')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_workouts_search_vector ON workouts USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_workouts_entries_search_vector ON workouts_entries USING GIN (search_vector);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_workouts_entries_search_vector;
+DROP INDEX IF EXISTS idx_workouts_search_vector;
+ALTER TABLE workouts_entries DROP COLUMN search_vector;
+ALTER TABLE workouts DROP COLUMN search_vector;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up 
+-- +goose StatementBegin
+UPDATE workouts_entries e
+SET order_index = n.position
+FROM (
+  SELECT id, ROW_NUMBER() OVER (PARTITION BY workout_id ORDER BY order_index, id) AS position
+  FROM workouts_entries
+) n
+WHERE e.id = n.id AND e.order_index <> n.position;
+
+CREATE INDEX IF NOT EXISTS idx_workouts_entries_workout_id_order ON workouts_entries(workout_id, order_index);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_workouts_entries_workout_id_order;
+-- +goose StatementEnd
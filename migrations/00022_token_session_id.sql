@@ -0,0 +1,28 @@
+-- +goose Up 
+-- +goose StatementBegin
+-- a session keeps the same id while its refresh token is rotated
+CREATE SEQUENCE IF NOT EXISTS token_session_id_seq;
+
+ALTER TABLE token
+ADD COLUMN session_id BIGINT;
+
+UPDATE token
+SET session_id = sessions.id
+FROM (
+    SELECT family, nextval('token_session_id_seq') AS id
+    FROM token
+    WHERE family <> '' AND scope IN ('authentication', 'refresh')
+    GROUP BY family
+) sessions
+WHERE token.family = sessions.family AND token.scope IN ('authentication', 'refresh');
+
+CREATE INDEX IF NOT EXISTS idx_token_session_id ON token(session_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_token_session_id;
+ALTER TABLE token
+DROP COLUMN session_id;
+DROP SEQUENCE IF EXISTS token_session_id_seq;
+-- +goose StatementEnd